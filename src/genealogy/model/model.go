@@ -0,0 +1,528 @@
+// Package model holds the people and facts read from the genealogy pages.
+// The types are shared by ingest, which builds them, the exporters, and the
+// server, which reads them back from mongo, so every field is exported and
+// tagged for both BSON and JSON.
+package model
+
+import (
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+    "unicode"
+)
+
+type Location struct {
+    Town string `bson:"town,omitempty" json:"town,omitempty"`
+    County string `bson:"county,omitempty" json:"county,omitempty"`
+    State string `bson:"state,omitempty" json:"state,omitempty"`
+}
+
+func (l Location) IsZero() bool {
+    return l == Location{}
+}
+
+// String gives the place from the town out, e.g. "Indian Valley, Floyd, VA"
+func (l Location) String() string {
+    parts := make([]string, 0)
+
+    for _, p := range([]string{l.Town, l.County, l.State}) {
+        if p != "" {
+            parts = append(parts, p)
+        }
+    }
+
+    return strings.Join(parts, ", ")
+}
+
+type DateQualifier string
+
+const (
+    Exact DateQualifier = ""
+    About DateQualifier = "about"
+    Before DateQualifier = "before"
+    After DateQualifier = "after"
+    Between DateQualifier = "between"
+)
+
+// A Date may be partial: a zero day, or a zero day and month, means that
+// part of the date is not known.  For Between, End holds the later bound.
+type Date struct {
+    Year int `bson:"year,omitempty" json:"year,omitempty"`
+    Month time.Month `bson:"month,omitempty" json:"month,omitempty"`
+    Day int `bson:"day,omitempty" json:"day,omitempty"`
+    Qualifier DateQualifier `bson:"qualifier,omitempty" json:"qualifier,omitempty"`
+    End *Date `bson:"end,omitempty" json:"end,omitempty"`
+}
+
+func (d Date) IsZero() bool {
+    return d.Year == 0 && d.Month == 0 && d.Day == 0
+}
+
+// SimpleString formats the date without its qualifier, e.g. "5 Sep 1790"
+func (d Date) SimpleString() string {
+    str := strconv.Itoa(d.Year)
+
+    if d.Month != 0 {
+        str = d.Month.String()[:3] + " " + str
+    }
+
+    if d.Day != 0 {
+        str = strconv.Itoa(d.Day) + " " + str
+    }
+
+    return str
+}
+
+// String formats the date as it is written in the source pages, e.g.
+// "about 1846" or "between 1760 and 1770"
+func (d Date) String() string {
+    if d.IsZero() {
+        return ""
+    }
+
+    switch d.Qualifier {
+    case About, Before, After:
+        return string(d.Qualifier) + " " + d.SimpleString()
+    case Between:
+        if d.End != nil {
+            return "between " + d.SimpleString() + " and " + d.End.SimpleString()
+        }
+    }
+
+    return d.SimpleString()
+}
+
+func qualifierRank(q DateQualifier) int {
+    switch q {
+    case Before:
+        return 0
+    case After:
+        return 2
+    }
+    return 1
+}
+
+// sortKey gives the year, month, day and qualifier rank a date sorts by.
+// "after" a partial date is after every date within it, so its missing parts
+// sort last.
+func (d Date) sortKey() []int {
+    month, day := int(d.Month), d.Day
+    if d.Qualifier == After {
+        if month == 0 {
+            month = 13
+        }
+        if day == 0 {
+            day = 32
+        }
+    }
+    return []int{d.Year, month, day, qualifierRank(d.Qualifier)}
+}
+
+// Compare orders dates chronologically, returning -1, 0 or +1.  Partial
+// dates compare on the parts they have, so "1846" sorts before "Mar 1846",
+// except that "after 1846" sorts after "Dec 1846".  On the same date
+// "before" sorts first and "after" last, and a range sorts by its start.
+// Dates with no year sort after every dated one.
+func (d Date) Compare(o Date) int {
+    if d.Year == 0 || o.Year == 0 {
+        if d.Year == o.Year {
+            return 0
+        } else if d.Year == 0 {
+            return 1
+        }
+        return -1
+    }
+
+    dKey := d.sortKey()
+    oKey := o.sortKey()
+
+    for i := range(dKey) {
+        if dKey[i] < oKey[i] {
+            return -1
+        } else if dKey[i] > oKey[i] {
+            return 1
+        }
+    }
+
+    return 0
+}
+
+func (d Date) Before(o Date) bool {
+    return d.Compare(o) < 0
+}
+
+// Sources holds the IDs of the fowsrc.htm entries cited for the sentence the
+// event was parsed from
+type DatedEvent struct {
+    Date Date `bson:"date" json:"date"`
+    Place Location `bson:"place" json:"place"`
+    Sources []string `bson:"sources,omitempty" json:"sources,omitempty"`
+}
+
+// String gives the date and place as they read in a sentence, e.g. "on 19
+// Oct 1864 in Wyoming, WV"
+func (e *DatedEvent) String() string {
+    parts := make([]string, 0)
+
+    if date := e.Date.String(); date != "" {
+        if e.Date.Qualifier == Exact && e.Date.Day != 0 {
+            date = "on " + date
+        } else if e.Date.Qualifier == Exact {
+            date = "in " + date
+        }
+        parts = append(parts, date)
+    }
+
+    if place := e.Place.String(); place != "" {
+        parts = append(parts, "in " + place)
+    }
+
+    return strings.Join(parts, " ")
+}
+
+// ByDate sorts events chronologically
+type ByDate []*DatedEvent
+
+func (e ByDate) Len() int { return len(e) }
+func (e ByDate) Swap(i, j int) { e[i], e[j] = e[j], e[i] }
+func (e ByDate) Less(i, j int) bool { return e[i].Date.Before(e[j].Date) }
+
+type Child struct {
+    Identifier string `bson:"identifier" json:"identifier"`
+    Name string `bson:"name" json:"name"`
+}
+
+type Marriage struct {
+    OtherIdentifier string `bson:"otheridentifier,omitempty" json:"otherIdentifier,omitempty"`
+    OtherName string `bson:"othername,omitempty" json:"otherName,omitempty"`
+    Children []*Child `bson:"children" json:"children"`
+    Date *DatedEvent `bson:"date,omitempty" json:"date,omitempty"`
+    Bond *DatedEvent `bson:"bond,omitempty" json:"bond,omitempty"`
+    Divorce *DatedEvent `bson:"divorce,omitempty" json:"divorce,omitempty"`
+}
+
+func NewMarriage() *Marriage {
+    m := new(Marriage)
+    m.Children = make([]*Child, 0)
+    return m
+}
+
+type Gender string
+
+const (
+    UnknownGender Gender = ""
+    Male Gender = "male"
+    Female Gender = "female"
+)
+
+type Parent struct {
+    Identifier string `bson:"identifier" json:"identifier"`
+    Name string `bson:"name" json:"name"`
+    // Whether this is the father or the mother, once known
+    Gender Gender `bson:"gender,omitempty" json:"gender,omitempty"`
+}
+
+type Occupation struct {
+    Name string `bson:"name" json:"name"`
+    Date *DatedEvent `bson:"date,omitempty" json:"date,omitempty"`
+}
+
+// Description is a physical description, mostly taken from draft cards.
+// Text is the description as written and the remaining fields are picked out
+// of it; anything that isn't height, build, eyes or hair is kept in Remarks.
+type Description struct {
+    Text string `bson:"text" json:"text"`
+    Height string `bson:"height,omitempty" json:"height,omitempty"`
+    Build string `bson:"build,omitempty" json:"build,omitempty"`
+    Eyes string `bson:"eyes,omitempty" json:"eyes,omitempty"`
+    Hair string `bson:"hair,omitempty" json:"hair,omitempty"`
+    Remarks []string `bson:"remarks,omitempty" json:"remarks,omitempty"`
+    Date *DatedEvent `bson:"date,omitempty" json:"date,omitempty"`
+}
+
+type Burial struct {
+    Cemetery string `bson:"cemetery,omitempty" json:"cemetery,omitempty"`
+    Date *DatedEvent `bson:"date,omitempty" json:"date,omitempty"`
+}
+
+// Residence is where a person lived.  Address, when known, is the street,
+// box or farm, e.g. "RFD 2", and Household describes who they lived with,
+// e.g. "his mother-in-law Margaret".
+type Residence struct {
+    Date *DatedEvent `bson:"date,omitempty" json:"date,omitempty"`
+    Address string `bson:"address,omitempty" json:"address,omitempty"`
+    Household string `bson:"household,omitempty" json:"household,omitempty"`
+}
+
+type EventType string
+
+const (
+    BirthEvent EventType = "birth"
+    BaptismEvent EventType = "baptism"
+    ChristeningEvent EventType = "christening"
+    AdoptionEvent EventType = "adoption"
+    CensusEvent EventType = "census"
+    OccupationEvent EventType = "occupation"
+    ResidenceEvent EventType = "residence"
+    DescriptionEvent EventType = "description"
+    MarriageBondEvent EventType = "marriage bond"
+    MarriageEvent EventType = "marriage"
+    DivorceEvent EventType = "divorce"
+    DeathEvent EventType = "death"
+    BurialEvent EventType = "burial"
+    MilitaryEvent EventType = "military"
+)
+
+// Participant is someone else who took part in an event, such as the spouse
+// in a marriage
+type Participant struct {
+    Identifier string `bson:"identifier,omitempty" json:"identifier,omitempty"`
+    Name string `bson:"name" json:"name"`
+    Role string `bson:"role" json:"role"`
+}
+
+// Event is a dated fact of any kind.  Detail is whatever the kind of event
+// adds to its date and place, such as the occupation or the cemetery.
+type Event struct {
+    Type EventType `bson:"type" json:"type"`
+    DatedEvent `bson:",inline"`
+    Detail string `bson:"detail,omitempty" json:"detail,omitempty"`
+    Participants []*Participant `bson:"participants,omitempty" json:"participants,omitempty"`
+}
+
+// NewEvent makes an event of the given type from a parsed date and place,
+// which may be nil when neither is known
+func NewEvent(t EventType, e *DatedEvent, detail string) *Event {
+    ev := &Event{ Type : t, Detail : detail }
+    if e != nil {
+        ev.DatedEvent = *e
+    }
+    return ev
+}
+
+// String describes the event after its type, e.g. "farmer in 1918 in Basin,
+// Wyoming, WV" or "Armenita Jane Blankenship on 19 Oct 1864 in Wyoming, WV"
+func (e *Event) String() string {
+    parts := make([]string, 0)
+
+    if e.Detail != "" {
+        parts = append(parts, e.Detail)
+    }
+
+    for _, p := range(e.Participants) {
+        if p.Name != "" {
+            parts = append(parts, p.Name)
+        }
+    }
+
+    if when := e.DatedEvent.String(); when != "" {
+        parts = append(parts, when)
+    }
+
+    return strings.Join(parts, " ")
+}
+
+// Record is everything known about one person.  The kinds of event the
+// pages have always given, such as birth, census and marriage, keep fields
+// of their own, which the exporters read and the server sorts and queries
+// by; kinds added since go in Events.  Timeline gathers both into one list.
+type Record struct {
+    Title string `bson:"title,omitempty" json:"title,omitempty"`
+    FirstName string `bson:"firstname" json:"firstName"`
+    MiddleName string `bson:"middlename" json:"middleName"`
+    LastName string `bson:"lastname" json:"lastName"`
+    Suffix string `bson:"suffix,omitempty" json:"suffix,omitempty"`
+    Identifier string `bson:"identifier" json:"identifier"`
+    Gender Gender `bson:"gender,omitempty" json:"gender,omitempty"`
+    Text string `bson:"text" json:"text"`
+    Marriages []*Marriage `bson:"marriages" json:"marriages"`
+    // The father and mother, in that order once the parents are labelled
+    Parents [2]*Parent `bson:"parents" json:"parents"`
+    // Children not known to belong to one of the Marriages, which hold the
+    // rest
+    Children []*Child `bson:"children" json:"children"`
+    BirthDate *DatedEvent `bson:"birthdate,omitempty" json:"birthDate,omitempty"`
+    Census []*DatedEvent `bson:"census" json:"census"`
+    Death *DatedEvent `bson:"death,omitempty" json:"death,omitempty"`
+    // How or of what the person died, e.g. "of consumption" or "in infancy"
+    DeathCause string `bson:"deathcause,omitempty" json:"deathCause,omitempty"`
+    Burial *Burial `bson:"burial,omitempty" json:"burial,omitempty"`
+    Baptism *DatedEvent `bson:"baptism,omitempty" json:"baptism,omitempty"`
+    Christening *DatedEvent `bson:"christening,omitempty" json:"christening,omitempty"`
+    Adoption *DatedEvent `bson:"adoption,omitempty" json:"adoption,omitempty"`
+    Residences []*Residence `bson:"residences" json:"residences"`
+    Aliases []string `bson:"aliases" json:"aliases"`
+    Occupations []*Occupation `bson:"occupations" json:"occupations"`
+    Descriptions []*Description `bson:"descriptions" json:"descriptions"`
+    // Events of the kinds which have no field of their own, such as
+    // military service.  A new kind of event belongs here, not in a new
+    // field.
+    Events []*Event `bson:"events" json:"events"`
+    // The words of the title, name and aliases, which name searches match,
+    // set by SetNameWords before the record is stored
+    NameWords []string `bson:"namewords,omitempty" json:"-"`
+}
+
+func NewRecord() *Record {
+    rec := new(Record)
+    rec.Marriages = make([]*Marriage, 0)
+    rec.Children = make([]*Child, 0)
+    rec.Census = make([]*DatedEvent, 0)
+    rec.Occupations = make([]*Occupation, 0)
+    rec.Aliases = make([]string, 0)
+    rec.Residences = make([]*Residence, 0)
+    rec.Descriptions = make([]*Description, 0)
+    rec.Events = make([]*Event, 0)
+    return rec
+}
+
+// AllChildren gives the children of each marriage in turn followed by those
+// of no known marriage
+func (r *Record) AllChildren() []*Child {
+    children := make([]*Child, 0, len(r.Children))
+    for _, m := range(r.Marriages) {
+        children = append(children, m.Children...)
+    }
+    return append(children, r.Children...)
+}
+
+// Timeline returns every event of the person's life, both those with fields
+// of their own and Events, sorted chronologically.  Events without a date
+// keep their place among themselves after the dated ones.
+func (r *Record) Timeline() []*Event {
+    events := make([]*Event, 0)
+
+    // Adds the event for a field which is nil when it didn't happen
+    add := func(t EventType, e *DatedEvent, detail string) *Event {
+        if e == nil {
+            return nil
+        }
+        ev := NewEvent(t, e, detail)
+        events = append(events, ev)
+        return ev
+    }
+
+    add(BirthEvent, r.BirthDate, "")
+    add(BaptismEvent, r.Baptism, "")
+    add(ChristeningEvent, r.Christening, "")
+    add(AdoptionEvent, r.Adoption, "")
+
+    for _, c := range(r.Census) {
+        add(CensusEvent, c, "")
+    }
+
+    for _, o := range(r.Occupations) {
+        events = append(events, NewEvent(OccupationEvent, o.Date, o.Name))
+    }
+
+    for _, res := range(r.Residences) {
+        detail := res.Address
+        if res.Household != "" {
+            detail = strings.TrimSpace(detail + " with " + res.Household)
+        }
+        events = append(events, NewEvent(ResidenceEvent, res.Date, detail))
+    }
+
+    for _, d := range(r.Descriptions) {
+        events = append(events, NewEvent(DescriptionEvent, d.Date, d.Text))
+    }
+
+    for _, m := range(r.Marriages) {
+        spouse := &Participant{ Identifier : m.OtherIdentifier, Name : m.OtherName, Role : "spouse" }
+
+        bond := add(MarriageBondEvent, m.Bond, "")
+
+        // The marriage itself happened even when its date isn't known
+        married := NewEvent(MarriageEvent, m.Date, "")
+        events = append(events, married)
+
+        for _, ev := range([]*Event{ bond, married, add(DivorceEvent, m.Divorce, "") }) {
+            if ev != nil {
+                ev.Participants = []*Participant{spouse}
+            }
+        }
+    }
+
+    add(DeathEvent, r.Death, r.DeathCause)
+    if r.Burial != nil {
+        events = append(events, NewEvent(BurialEvent, r.Burial.Date, r.Burial.Cemetery))
+    }
+
+    events = append(events, r.Events...)
+
+    sort.SliceStable(events, func(i, j int) bool {
+        return events[i].Date.Before(events[j].Date)
+    })
+
+    return events
+}
+
+func (r *Record) Father() *Parent {
+    return r.Parents[0]
+}
+
+func (r *Record) Mother() *Parent {
+    return r.Parents[1]
+}
+
+func (r *Record) FullName() string {
+    return strings.Join(strings.Fields(r.FirstName + " " + r.MiddleName +
+                    " " + r.LastName + " " + r.Suffix), " ")
+}
+
+// Names returns every name the person is known by, their own followed by
+// their aliases
+func (r *Record) Names() []string {
+    names := make([]string, 0, len(r.Aliases) + 1)
+
+    if name := r.FullName(); name != "" {
+        names = append(names, name)
+    }
+
+    return append(names, r.Aliases...)
+}
+
+// NameWords splits a name into its lower cased words, dropping the
+// punctuation around them, so "Jno E." is "jno" and "e"
+func NameWords(name string) []string {
+    return strings.FieldsFunc(strings.ToLower(name), func(c rune) bool {
+        return !unicode.IsLetter(c) && !unicode.IsDigit(c) && c != '\''
+    })
+}
+
+// SetNameWords gathers the words of every name the person is known by, each
+// once, into NameWords
+func (r *Record) SetNameWords() {
+    r.NameWords = make([]string, 0)
+    seen := make(map[string]bool, 0)
+
+    for _, name := range(append([]string{r.Title}, r.Names()...)) {
+        for _, w := range(NameWords(name)) {
+            if !seen[w] {
+                seen[w] = true
+                r.NameWords = append(r.NameWords, w)
+            }
+        }
+    }
+}
+
+// GivenNames returns the lower cased first word of the person's name and of
+// each alias, e.g. "john" and "jno" for John Graham aka Jno E
+func (r *Record) GivenNames() []string {
+    given := make([]string, 0)
+
+    for _, name := range(r.Names()) {
+        if words := strings.Fields(name); len(words) > 0 {
+            given = append(given, strings.ToLower(words[0]))
+        }
+    }
+
+    return given
+}
+
+// Source is an entry of the fowsrc.htm source list
+type Source struct {
+    Identifier string `bson:"identifier" json:"identifier"`
+    Text string `bson:"text" json:"text"`
+}
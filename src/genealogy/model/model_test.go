@@ -0,0 +1,79 @@
+package model
+
+import (
+    "sort"
+    "testing"
+    "time"
+)
+
+func TestDateCompare(t *testing.T) {
+    tests := []struct {
+        a Date
+        b Date
+        want int
+    }{
+        {Date{ Year : 1846 }, Date{ Year : 1847 }, -1},
+        {Date{ Year : 1846, Month : time.March }, Date{ Year : 1846 }, 1},
+        {Date{ Year : 1846, Month : time.March, Day : 2 }, Date{ Year : 1846, Month : time.March, Day : 2 }, 0},
+        {Date{ Year : 1846, Qualifier : About }, Date{ Year : 1846 }, 0},
+        {Date{ Year : 1846, Qualifier : Before }, Date{ Year : 1846 }, -1},
+        {Date{ Year : 1846, Qualifier : After }, Date{ Year : 1846 }, 1},
+        {Date{ Year : 1846, Qualifier : After }, Date{ Year : 1847, Qualifier : Before }, -1},
+        // "after 1846" is after all of 1846
+        {Date{ Year : 1846, Qualifier : After }, Date{ Year : 1846, Month : time.December, Day : 31 }, 1},
+        {Date{ Year : 1846, Month : time.June, Qualifier : After }, Date{ Year : 1846, Month : time.June, Day : 30 }, 1},
+        {Date{ Year : 1846, Month : time.June, Qualifier : Before }, Date{ Year : 1846, Month : time.June, Day : 1 }, -1},
+        // A range sorts by its start
+        {Date{ Year : 1760, Qualifier : Between, End : &Date{ Year : 1770 } }, Date{ Year : 1765 }, -1},
+        // Undated sorts last
+        {Date{}, Date{ Year : 1900 }, 1},
+        {Date{}, Date{}, 0},
+    }
+
+    for _, test := range(tests) {
+        if got := test.a.Compare(test.b); got != test.want {
+            t.Errorf("`%s` compared to `%s` gives %d, want %d", test.a, test.b, got, test.want)
+        }
+        if got := test.b.Compare(test.a); got != -test.want {
+            t.Errorf("`%s` compared to `%s` gives %d, want %d", test.b, test.a, got, -test.want)
+        }
+    }
+}
+
+func TestDateString(t *testing.T) {
+    tests := []struct {
+        d Date
+        want string
+    }{
+        {Date{ Year : 1790, Month : time.September, Day : 5 }, "5 Sep 1790"},
+        {Date{ Year : 1846, Qualifier : About }, "about 1846"},
+        {Date{ Year : 1882, Qualifier : Before }, "before 1882"},
+        {Date{ Year : 1760, Qualifier : Between, End : &Date{ Year : 1770 } }, "between 1760 and 1770"},
+        {Date{}, ""},
+    }
+
+    for _, test := range(tests) {
+        if got := test.d.String(); got != test.want {
+            t.Errorf("`%s`, want `%s`", got, test.want)
+        }
+    }
+}
+
+func TestByDate(t *testing.T) {
+    events := []*DatedEvent{
+        &DatedEvent{ Date : Date{} },
+        &DatedEvent{ Date : Date{ Year : 1880 } },
+        &DatedEvent{ Date : Date{ Year : 1850, Qualifier : After } },
+        &DatedEvent{ Date : Date{ Year : 1850, Month : time.June } },
+        &DatedEvent{ Date : Date{ Year : 1850 } },
+    }
+
+    sort.Stable(ByDate(events))
+
+    want := []string{"in 1850", "in Jun 1850", "after 1850", "in 1880", ""}
+    for i, e := range(events) {
+        if e.String() != want[i] {
+            t.Errorf("event %d is `%s`, want `%s`", i, e.String(), want[i])
+        }
+    }
+}
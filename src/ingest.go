@@ -1,20 +1,31 @@
 package main
 
 import (
+    "bufio"
     "code.google.com/p/go.net/html"
     "flag"
     "fmt"
+    "genealogy/model"
+    "io"
     "io/ioutil"
     "log"
-    _ "labix.org/v2/mgo"
+    "labix.org/v2/mgo"
+    "labix.org/v2/mgo/bson"
+    "os"
     "path"
+    "sort"
     "strconv"
     "strings"
     "time"
+    "unicode"
+    "unicode/utf8"
 )
 
 const MAX_MONTH_DAYS = 31
 
+// The page listing the sources cited by the <SUP> links
+const SOURCE_PAGE = "fowsrc.htm"
+
 var monthMap = map[string]time.Month {
     "Jan" : time.January,
     "Feb" : time.February,
@@ -29,92 +40,40 @@ var monthMap = map[string]time.Month {
     "Nov" : time.November,
     "Dec" : time.December,
 }
-type Location struct {
-    county string
-    state string
-    town string
-}
-
-type Date struct {
-    year int
-    month time.Month
-    day int
-}
-
-type DatedEvent struct {
-    d Date
-    loc Location
-}
-
-type Child struct {
-    Identifier string
-    Name string
-}
-
-type Marriage struct {
-    OtherIdentifier string
-    OtherName string
-    Children []*Child
-    Date *DatedEvent
-}
-
-type Parent struct {
-    Identifier string
-    Name string
-}
-
-type Gender int
-
-const (
-    Male = iota
-    Female = iota
-)
-
-type Occupation struct {
-    Name string
-    Date *DatedEvent
-}
-
-type Description struct {
-    Text string
-    Date *DatedEvent
-}
 
-type Residence struct {
-    Date *DatedEvent
+var dateQualifierMap = map[string]model.DateQualifier {
+    "on" : model.Exact,
+    "in" : model.Exact,
+    "about" : model.About,
+    "abt" : model.About,
+    "before" : model.Before,
+    "after" : model.After,
+    "between" : model.Between,
 }
 
+// Record is a person whose paragraph is being read, along with what one
+// sentence leaves for the next to build on
 type Record struct {
-    FirstName string
-    MiddleName string
-    LastName string
-    Identifier string
-    Text string
-    Marriages []*Marriage
-    Parents [2]*Parent
-    Children []*Child
-    BirthDate *DatedEvent
-    Census *DatedEvent
-    Death *DatedEvent
-    Residences []*Residence
+    model.Record
     residenceIdx int
-    Alias string
-    Occ *Occupation
-    Desc *Description
-    gender Gender
+    // The most recent census or residence, which a following "was living
+    // with" sentence describes
+    lastPlaced *model.DatedEvent
     curMarriageIdx int
 }
 
 func NewRecord() *Record {
-    rec := new(Record)
-    rec.Children = make([]*Child, 0)
-    return rec
+    return &Record{ Record : *model.NewRecord() }
 }
+
 type Document struct {
     Paragraphs []*Paragraph
 }
 
 type Paragraph struct {
+    Identifier string
+    // The person's name from the <B> header
+    Name string
     Data string
     Frags []*Frag
     NormalizedFrags []*Frag
@@ -171,6 +130,44 @@ type Frag struct {
     IsSup bool
 }
 
+// ParseError describes input which could not be parsed.  Errors start out
+// carrying only Msg and are filled in with the sentence, person and file as
+// they are passed back up through GenerateRecords and main.
+type ParseError struct {
+    File string
+    Identifier string
+    Sentence string
+    Msg string
+}
+
+func NewParseError(format string, args ...interface{}) *ParseError {
+    return &ParseError{ Msg : fmt.Sprintf(format, args...) }
+}
+
+func (e *ParseError) Error() string {
+    str := e.File
+
+    if e.Identifier != "" {
+        str += "#" + e.Identifier
+    }
+
+    str += ": " + e.Msg
+
+    if e.Sentence != "" {
+        str += fmt.Sprintf(" in `%s`", e.Sentence)
+    }
+
+    return str
+}
+
+// AsParseError returns err as a *ParseError so that it can be annotated
+func AsParseError(err error) *ParseError {
+    if pe, ok := err.(*ParseError); ok {
+        return pe
+    }
+    return &ParseError{ Msg : err.Error() }
+}
+
 func PrintTag(level int, n *html.Node) {
     //fmt.Printf("%-12s", ds)
     for i := 1; i < level; i++ {
@@ -186,32 +183,74 @@ func PrintTag(level int, n *html.Node) {
     }
 }
 
-func ProcessPersonIdentifier(n *html.Node) string {
+func ProcessPersonIdentifier(n *html.Node) (string, error) {
     for _, a := range(n.Attr) {
         if a.Key != "name" {
-            log.Fatal("Error: expected `name` tag attribute")
+            return "", NewParseError("expected `name` tag attribute, found `%s`",
+                            a.Key)
         } else {
-            return strings.TrimSpace(a.Val)
+            return strings.TrimSpace(a.Val), nil
         }
     }
-    return ""
+    return "", nil
 }
 
-func ProcessAncestorReference(n *html.Node) (string, string) {
+func ProcessAncestorReference(n *html.Node) (string, string, error) {
     for _, a := range(n.Attr) {
         if a.Key != "href" {
-            log.Fatal("Error: expected `href` tag attribute")
+            return "", "", NewParseError("expected `href` tag attribute, found `%s`",
+                            a.Key)
         } else if !strings.HasSuffix(a.Val, ".htm") {
-            return strings.TrimSpace(strings.Split(a.Val, "#")[1]),
-                    n.FirstChild.Data
+            refToks := strings.Split(a.Val, "#")
+
+            if len(refToks) != 2 {
+                return "", "", NewParseError("unexpected reference `%s`", a.Val)
+            }
+
+            return strings.TrimSpace(refToks[1]), n.FirstChild.Data, nil
+        }
+    }
+    return "", "", nil
+}
+
+// ProcessSourceReference returns the ID of the source cited by a
+// <SUP><A HREF="fowsrc.htm#629">(629)</A></SUP> link
+func ProcessSourceReference(n *html.Node) string {
+    for _, a := range(n.Attr) {
+        if a.Key == "href" {
+            if refToks := strings.Split(a.Val, "#"); len(refToks) == 2 {
+                return strings.TrimSpace(refToks[1])
+            }
         }
     }
-    return "", ""
+
+    if n.FirstChild == nil {
+        return ""
+    }
+
+    return strings.Trim(strings.TrimSpace(n.FirstChild.Data), "()")
 }
 
-func ProcessDocument(n *html.Node) *Document {
+// ProcessDocument splits the page into one paragraph per person.  Anchors
+// which can't be understood are skipped and reported in the returned errors.
+func ProcessDocument(n *html.Node) (*Document, []*ParseError) {
     var para *Paragraph = nil
 
+    errs := make([]*ParseError, 0)
+
+    // Attributes the error to the person whose paragraph is being read
+    addError := func(err error) {
+        pe := AsParseError(err)
+        if para != nil {
+            pe.Identifier = para.Identifier
+        }
+        errs = append(errs, pe)
+    }
+
+    // The <A NAME="P####"> anchor precedes the <B> tag that opens the
+    // paragraph it identifies
+    var pendingId string
+
     doc := new(Document)
 
     doc.Paragraphs = make([]*Paragraph, 0)
@@ -235,21 +274,24 @@ func ProcessDocument(n *html.Node) *Document {
                         //fmt.Printf("-------------------------------\n")
                         //fmt.Printf(para.Data)
                         //fmt.Printf("-------------------------------\n")
-                        return doc
+                        return doc, errs
                     }
                 }
 
-                if para != nil {
-                    if curNode.FirstChild != nil {
-                        ref, data := ProcessAncestorReference(curNode)
-                        para.Data += curNode.FirstChild.Data
-                        f := &Frag{data, ref, "", false}
-                        para.Frags = append(para.Frags, f)
-                    } else {
-                        id := ProcessPersonIdentifier(curNode)
-                        f := &Frag{"", "", id, false}
-                        para.Frags = append(para.Frags, f)
+                if curNode.FirstChild == nil {
+                    id, err := ProcessPersonIdentifier(curNode)
+                    if err != nil {
+                        addError(err)
                     }
+                    pendingId = id
+                } else if para != nil {
+                    ref, data, err := ProcessAncestorReference(curNode)
+                    if err != nil {
+                        addError(err)
+                    }
+                    para.Data += curNode.FirstChild.Data
+                    f := &Frag{data, ref, "", false}
+                    para.Frags = append(para.Frags, f)
                 }
                 curNode = curNode.NextSibling
             } else if curNode.Data == "b" {
@@ -261,7 +303,10 @@ func ProcessDocument(n *html.Node) *Document {
                 }
                 // new paragraph
                 para = new(Paragraph)
+                para.Identifier = pendingId
+                pendingId = ""
                 para.Data += curNode.FirstChild.Data
+                para.Name = strings.Join(strings.Fields(curNode.FirstChild.Data), " ")
                 fmt.Printf("Name: %s\n", curNode.FirstChild.Data)
                 f := &Frag{curNode.FirstChild.Data, "", "", false}
                 para.Frags = append(para.Frags, f)
@@ -274,10 +319,14 @@ func ProcessDocument(n *html.Node) *Document {
                     if sub.Data == "a" {
                         para.Data += sub.FirstChild.Data
 
-                        ref, data := ProcessAncestorReference(sub)
+                        ref, data, err := ProcessAncestorReference(sub)
+                        if err != nil {
+                            addError(err)
+                            continue
+                        }
                         if ref == "" && data == "" {
                             doc.Paragraphs = append(doc.Paragraphs, para)
-                            return doc
+                            return doc, errs
                         }
                         //fmt.Printf("Ref: %s %s\n", ref, data)
                         f := &Frag{data, ref, "", false}
@@ -288,7 +337,7 @@ func ProcessDocument(n *html.Node) *Document {
                         for sub2 := sub.FirstChild; sub2 != nil; sub2 = sub2.NextSibling {
                             if sub2.Data == "a" {
                                 var f *Frag
-                                ref := sub2.FirstChild.Data
+                                ref := ProcessSourceReference(sub2)
                                 f = &Frag{"", ref, "", true}
                                 para.Frags = append(para.Frags, f)
                                 break
@@ -308,7 +357,7 @@ func ProcessDocument(n *html.Node) *Document {
 
                 for sub := curNode.FirstChild; sub != nil; sub = sub.NextSibling {
                     if sub.Data == "a" {
-                        ref = sub.FirstChild.Data
+                        ref = ProcessSourceReference(sub)
                         f = &Frag{"", ref, "", true}
                         para.Frags = append(para.Frags, f)
                         break
@@ -324,7 +373,7 @@ func ProcessDocument(n *html.Node) *Document {
             }
         } else {
             // handle the paragraph
-            fmt.Printf(curNode.Data)
+            fmt.Print(curNode.Data)
             if para != nil {
                 para.Data += curNode.Data
                 f := &Frag{curNode.Data, "", "", false}
@@ -337,7 +386,7 @@ func ProcessDocument(n *html.Node) *Document {
     //fmt.Printf("-------------------------------\n")
     //fmt.Printf(para.Data)
     //fmt.Printf("-------------------------------\n")
-    return doc
+    return doc, errs
 }
 
 func Normalize(doc *Document) {
@@ -419,327 +468,3280 @@ func ProcessSentences(doc *Document) {
     }
 }
 
-func ProcessBirth(s *Sentence, rec *Record) {
-    nameWords := strings.Split(s.Frags[0].Data, " ")
+// Titles which may lead a header name, lower case and without a trailing
+// period
+var nameTitles = map[string]bool {
+    "mr" : true,
+    "mrs" : true,
+    "miss" : true,
+    "ms" : true,
+    "dr" : true,
+    "rev" : true,
+    "elder" : true,
+    "col" : true,
+    "colonel" : true,
+    "capt" : true,
+    "captain" : true,
+    "gen" : true,
+    "lt" : true,
+    "sgt" : true,
+}
 
-    if len(nameWords) == 2 {
-        rec.FirstName = nameWords[0]
-        rec.LastName = nameWords[1]
-    } else if len(nameWords) == 3 {
-        rec.FirstName = nameWords[0]
-        rec.MiddleName = nameWords[1]
-        rec.LastName = nameWords[2]
-    } else {
-        rec.FirstName = nameWords[0]
-    }
+var nameSuffixes = map[string]bool {
+    "jr" : true,
+    "sr" : true,
+    "ii" : true,
+    "iii" : true,
+    "iv" : true,
+}
 
-    rec.BirthDate = ProcessDatedEvent(s.AllWords())
+// Particles which, ahead of the last word of a header name or of each other,
+// belong to the surname, as in "Berendena Van Leeuwen", lower case
+var surnameParticles = map[string]bool {
+    "van" : true,
+    "von" : true,
+    "der" : true,
+    "den" : true,
+    "de" : true,
+    "du" : true,
+    "da" : true,
+    "di" : true,
+    "la" : true,
+    "le" : true,
+    "mac" : true,
 }
 
-func ProcessParents(s *Sentence, rec *Record) {
+func nameKey(word string) string {
+    return strings.ToLower(strings.TrimSuffix(word, "."))
+}
 
-    idx := 0
-    for _, f := range(s.Frags) {
-        fmt.Printf("%s `%s` `%s`\n", f.Data, f.RefId, f.Identifier)
-        if f.RefId != "" {
-            p := &Parent{ Identifier : f.RefId, Name : f.Data }
-            rec.Parents[idx] = p
-            idx++
+// SetHeaderName fills in the record's name from a paragraph header such as
+// "Rev Norman Cornelius Reed", "Zoll Lewis Grim SR" or "unknown Rogers".
+// Every word between the first and last name is a middle name, a lone word
+// is a given name unless it follows a title, as in "Mr Churchill", and
+// "Unknown" stands for a name which is not known.  A particle ahead of the
+// last name, as in "Berendena Van Leeuwen", is part of it.  Alternatives
+// written "Elsie\Eliza Shickles" or "Sarah Sally Grimes\Graham", typically a
+// maiden and married surname, keep the first and add the others as aliases.
+func SetHeaderName(rec *model.Record, text string) {
+    words := strings.Fields(text)
+
+    titles := make([]string, 0)
+    for len(words) > 0 && nameTitles[nameKey(words[0])] {
+        titles = append(titles, words[0])
+        words = words[1:]
+    }
+    rec.Title = strings.Join(titles, " ")
+
+    if len(words) > 1 && nameSuffixes[nameKey(words[len(words) - 1])] {
+        rec.Suffix = words[len(words) - 1]
+        words = words[:len(words) - 1]
+    }
+
+    givenKnown := true
+    if len(words) > 0 && strings.EqualFold(words[0], "unknown") {
+        givenKnown = false
+        words = words[1:]
+    }
+    if len(words) > 0 && strings.EqualFold(words[len(words) - 1], "unknown") {
+        words = words[:len(words) - 1]
+    }
+
+    // Each word's alternatives, with "?" marking a doubtful reading dropped
+    variants := make([][]string, len(words))
+    for i, w := range(words) {
+        for _, v := range(strings.Split(w, "\\")) {
+            if v = strings.TrimRight(v, "?"); v != "" {
+                variants[i] = append(variants[i], v)
+            }
+        }
+        if len(variants[i]) == 0 {
+            variants[i] = []string{w}
         }
+        words[i] = variants[i][0]
     }
-}
 
-func ProcessChildren(s *Sentence, rec *Record) {
-    for _, f := range(s.Frags) {
-        if f.RefId != "" {
-            fmt.Printf("Child: `%s`\n", f.Data)
-            c := &Child { Identifier : f.RefId, Name : f.Data }
-            rec.Children = append(rec.Children, c)
+    // The surname starts at last, taking in the particles before it, as in
+    // "Maria de la Cruz", unless one is the given name, as in "Van Hale"
+    last := len(words) - 1
+    for last > 0 && surnameParticles[nameKey(words[last - 1])] &&
+            (last > 1 || rec.Title != "" || !givenKnown) {
+        last--
+    }
+
+    switch {
+    case len(words) == 0:
+    case last == 0 && (rec.Title != "" || !givenKnown):
+        rec.LastName = strings.Join(words, " ")
+    case len(words) == 1:
+        rec.FirstName = words[0]
+    case !givenKnown:
+        rec.MiddleName = strings.Join(words[:last], " ")
+        rec.LastName = strings.Join(words[last:], " ")
+    default:
+        rec.FirstName = words[0]
+        rec.MiddleName = strings.Join(words[1:last], " ")
+        rec.LastName = strings.Join(words[last:], " ")
+    }
+
+    for i, vs := range(variants) {
+        for _, v := range(vs[1:]) {
+            alias := make([]string, len(words))
+            copy(alias, words)
+            alias[i] = v
+            if rec.Suffix != "" {
+                alias = append(alias, rec.Suffix)
+            }
+            rec.Aliases = append(rec.Aliases, strings.Join(alias, " "))
         }
     }
 }
 
-func ProcessMarriage(s *Sentence, rec *Record) {
-    for _, f := range(s.Frags) {
-        if f.RefId != "" {
-            fmt.Printf("Married to: `%s`\n", f.Data)
-            m := &Marriage { OtherIdentifier : f.RefId, OtherName : f.Data }
-            rec.Marriages = append(rec.Marriages, m)
-            break
-        }
+func ProcessBirth(s *Sentence, rec *Record) error {
+    birth, err := ProcessDatedEvent(s.AllWords())
+    if err != nil {
+        return err
     }
 
-    //for _, f := range(s.Frags) {
+    birth.Sources = s.Sources()
 
-    //}
+    rec.BirthDate = birth
+
+    return nil
 }
 
-func ProcessCensus(s *Sentence, rec *Record) {
+// References returns the links to other people in the sentence.  Normalize
+// splits a link's text on double spaces, so consecutive fragments with the
+// same reference are joined back into one name.
+func (s *Sentence) References() []*Frag {
+    refs := make([]*Frag, 0)
 
-}
+    for _, f := range(s.Frags) {
+        if f.RefId == "" || f.IsSup {
+            continue
+        }
 
-func ProcessOccupation(s *Sentence, rec *Record) {
-}
+        if len(refs) > 0 && refs[len(refs) - 1].RefId == f.RefId {
+            refs[len(refs) - 1].Data += " " + f.Data
+            continue
+        }
 
-func ProcessAlias(s *Sentence, rec *Record) {
-}
+        refs = append(refs, &Frag{f.Data, f.RefId, f.Identifier, false})
+    }
 
-func ProcessBurial(s *Sentence, rec *Record) {
+    return refs
 }
 
-func ProcessDeath(s *Sentence, rec *Record) {
+// Sources returns the IDs of the sources cited at the end of the sentence
+func (s *Sentence) Sources() []string {
+    sources := make([]string, 0)
+
+    for _, f := range(s.Frags) {
+        if f.IsSup && f.RefId != "" {
+            sources = append(sources, f.RefId)
+        }
+    }
+
+    return sources
 }
 
-func ProcessMarriageBond(s *Sentence, rec *Record) {
+func ProcessParents(s *Sentence, rec *Record) error {
+
+    idx := 0
+    for _, f := range(s.References()) {
+        fmt.Printf("%s `%s` `%s`\n", f.Data, f.RefId, f.Identifier)
+        if idx == len(rec.Parents) {
+            return NewParseError("more than %d parents", len(rec.Parents))
+        }
+        p := &model.Parent{ Identifier : f.RefId, Name : f.Data }
+        rec.Parents[idx] = p
+        idx++
+    }
+
+    return nil
 }
 
-func ProcessResidence(s *Sentence, rec *Record) {
+func ProcessChildren(s *Sentence, rec *Record) error {
+    for _, f := range(s.References()) {
+        fmt.Printf("Child: `%s`\n", f.Data)
+        c := &model.Child { Identifier : f.RefId, Name : f.Data }
+        rec.Children = append(rec.Children, c)
+    }
+
+    return nil
 }
 
-func ProcessDescription(s *Sentence, rec *Record) {
+// Words which end the spouse's name in a marriage sentence
+var marriageDateWords = map[string]bool {
+    "on" : true,
+    "in" : true,
+    "about" : true,
+    "before" : true,
+    "after" : true,
+    "between" : true,
 }
 
-func GenerateRecords(doc *Document) []*Record {
+// ProcessMarriage handles "He was married to Mary Farmer before 1911" and,
+// when the spouse isn't known, "He was married on 10 Oct 1929 in Baker, OR"
+func ProcessMarriage(s *Sentence, rec *Record) error {
+    m := model.NewMarriage()
 
-    records := make([]*Record, 0)
+    words := SentenceWords(WordsAfter(s.AllWords(), "married"))
 
-    for _, p := range(doc.Paragraphs) {
-        rec := NewRecord()
+    named := len(words) > 0 && words[0] == "to"
 
-        for _, s := range(p.Sentences) {
-
-            if s.Contains("was born") {
-                ProcessBirth(s, rec)
-            } else if s.Contains("appeared on the census") {
-                //ProcessCensus(s, rec)
-            } else if s.Contains("Parents:") {
-                ProcessParents(s, rec)
-            } else if s.Contains("Children were:") {
-                ProcessChildren(s, rec)
-            } else if s.Contains("was married to") {
-                ProcessMarriage(s, rec)
-            } else if s.Contains("was a") {
-                //ProcessOccupation(s, rec)
-            } else if s.Contains("also known as") {
-                //ProcessAlias(s, rec)
-            } else if s.Contains("was buried") {
-                //ProcessBurial(s, rec)
-            } else if s.Contains("died") {
-                //ProcessDeath(s, rec)
-            } else if s.Contains("was described as") {
-                //ProcessDescription(s, rec)
-            } else if s.Contains("listed as being born") {
-                //ProcessBirthListing(s, rec)
-            } else if s.Contains("date of marriage bond") {
-                //ProcessMarriageBond(s, rec)
-            } else if s.Contains("resided in") {
-                //ProcessResidence(s, rec)
-            } else {
+    pos := 0
+    if named {
+        words = words[1:]
+
+        for _, f := range(s.References()) {
+            fmt.Printf("Married to: `%s`\n", f.Data)
+            m.OtherIdentifier = f.RefId
+            m.OtherName = f.Data
+            break
+        }
 
-                fmt.Printf("%s\n", s.String())
+        // The spouse's name runs up to the date or place
+        for ; pos < len(words); pos++ {
+            if marriageDateWords[words[pos]] {
+                break
             }
         }
-        records = append(records, rec)
+
+        // Spouses without a page of their own are not linked
+        if m.OtherName == "" {
+            m.OtherName = strings.Join(words[:pos], " ")
+        }
     }
 
-    return records
-}
+    date, err := ProcessDatedEvent(words[pos:])
+    if err != nil {
+        return err
+    }
 
-func IsDay(word string) (int, bool) {
-    day, err := strconv.Atoi(word)
+    // A bare "He was married" or "He was married for 1 year" says nothing
+    // to record
+    if !named && date.Date.IsZero() && date.Place.IsZero() {
+        return nil
+    }
 
-    if err != nil {
-        return day, false
+    date.Sources = s.Sources()
+    m.Date = date
+
+    // A divorce mentioned before the marriage has already added it
+    if prev := FindMarriage(rec, m.OtherIdentifier, m.OtherName); prev != nil && prev.Date == nil {
+        prev.OtherIdentifier = m.OtherIdentifier
+        prev.OtherName = m.OtherName
+        prev.Date = m.Date
+        return nil
     }
 
-    if day > MAX_MONTH_DAYS {
-        return day, false
+    rec.Marriages = append(rec.Marriages, m)
+    rec.curMarriageIdx = len(rec.Marriages) - 1
+
+    return nil
+}
+
+func ProcessCensus(s *Sentence, rec *Record) error {
+    // Skip past "appeared on the census" so its "on" isn't taken as the
+    // start of the date
+    census, err := ProcessDatedEvent(WordsAfter(s.AllWords(), "census"))
+    if err != nil {
+        return err
     }
 
-    return day, true
+    census.Sources = s.Sources()
+
+    rec.Census = append(rec.Census, census)
+    rec.lastPlaced = census
+
+    return nil
 }
 
-func IsMonth(word string) (time.Month, bool) {
-    month, ok := monthMap[word]
+// datedEventStart gives the position of the word starting the date or place
+// in words, or len(words) if there's neither.  The date is looked for first,
+// so the place of "miner in iron ore in 1900 in Hiawassie" starts after the
+// date, and only without one does the first "in" start the place.
+func datedEventStart(words []string) int {
+    place := len(words)
+    for pos := 0; pos < len(words); pos++ {
+        if _, isKey := dateQualifierMap[words[pos]]; !isKey {
+            continue
+        }
 
-    if !ok {
-        w := strings.TrimSuffix(word, ".")
+        if pos + 1 < len(words) && IsDateWord(words[pos + 1]) {
+            return pos
+        }
+        if words[pos] == "in" && place == len(words) {
+            place = pos
+        }
+    }
+    return place
+}
 
-        // We saw a string but it didn't resolve in our month map
-        if _, err := strconv.Atoi(w); err != nil {
-            log.Fatalf("Error: unexpected date element `%s`", w)
+// occupationStart gives the position of the word after "was a" or "was an",
+// or -1 if there's no such word
+func occupationStart(words []string) int {
+    for pos := 0; pos + 2 < len(words); pos++ {
+        if words[pos] == "was" && (words[pos + 1] == "a" || words[pos + 1] == "an") {
+            return pos + 2
         }
+    }
+    return -1
+}
 
-        return time.January, false
+// notOccupations are what people "was a" without it being their work, as in
+// "He was a widower with seven children" or "She was a member of the Church
+// of the Brethren"
+var notOccupations = []string{"widow", "widower", "member", "lifetime", "twin", "stepson",
+    "stepdaughter", "lodger"}
+
+// IsOccupation reports whether the sentence's "was a" or "was an" gives the
+// person's work
+func IsOccupation(s *Sentence) bool {
+    words := SentenceWords(s.AllWords())
+
+    start := occupationStart(words)
+    if start == -1 {
+        return false
     }
 
-    return month, true
+    return !hasName(notOccupations, strings.TrimRight(words[start], ",;"))
 }
 
-func IsYear(word string) (int, bool) {
-    var year int
-    var err error
+// ProcessOccupation handles "He was a farmer in 1918 in Basin, Wyoming Co.,
+// WV" and "She was an ..." sentences
+func ProcessOccupation(s *Sentence, rec *Record) error {
+    words := SentenceWords(s.AllWords())
 
-    w := strings.TrimSuffix(word, ".")
+    start := occupationStart(words)
+    if start == -1 {
+        return NewParseError("expected `was a` or `was an`")
+    }
 
-    if year, err = strconv.Atoi(w); err != nil {
-        return year, false
+    words = words[start:]
+
+    // The occupation, e.g. "miner for Thomas Coal & Coke Co.", runs up to
+    // the date or place
+    pos := datedEventStart(words)
+
+    if pos == 0 {
+        return NewParseError("occupation has no name")
     }
 
-    return year, true
+    date, err := ProcessDatedEvent(words[pos:])
+    if err != nil {
+        return err
+    }
+
+    date.Sources = s.Sources()
+
+    occ := &model.Occupation{ Name : strings.Join(words[:pos], " "), Date : date }
+    rec.Occupations = append(rec.Occupations, occ)
+
+    return nil
 }
 
-func ParseCounty(words string) string {
-    if !strings.HasSuffix(words, "Co.") {
-        log.Printf("Warning: county `%s` doesn't end as expected",
-                        words)
+// ProcessAlias handles "He was also known as Jno E" and lists such as "She
+// was also known as Liza, Lizzie, Eliza A or Annie E"
+func ProcessAlias(s *Sentence, rec *Record) error {
+    words := WordsAfter(SentenceWords(s.AllWords()), "as")
+
+    aliasText := strings.Replace(strings.Join(words, " "), " or ", ",", -1)
+
+    // The header may already have given aliases, so count this sentence's
+    found := 0
+    for _, a := range(strings.Split(aliasText, ",")) {
+        if a = strings.TrimSpace(a); a != "" {
+            rec.Aliases = append(rec.Aliases, a)
+            found++
+        }
     }
-    county := strings.TrimSuffix(words, "Co.")
 
-    return strings.TrimSpace(county)
+    if found == 0 {
+        return NewParseError("no alias found")
+    }
+
+    return nil
 }
 
-func ParseState(words string) string {
-    //if !strings.HasSuffix(words, ".") &&
-    //    !strings.HasSuffix(words, " . ") &&
-    //    !strings.HasSuffix(words, " .  ") &&
-    //    !strings.HasSuffix(words, " .") {
+// WordsAfter returns the words of the sentence following the first
+// occurrence of word, or all of them if word does not appear
+func WordsAfter(words []string, word string) []string {
+    for pos, w := range(words) {
+        if w == word {
+            return words[pos + 1:]
+        }
+    }
+    return words
+}
 
-    //    log.Fatalf("Error: state `%s` doesn't end as expected",
-    //                    words)
-    //}
-    state := strings.TrimSuffix(words, ".")
+var cemeteryWords = []string {
+    "Cemetery",
+    "Cem.",
+    "Cem",
+    "Graveyard",
+    "Gardens",
+    "Memorial",
+    "Mausoleum",
+    "Church",
+    "Chapel",
+    "Burial",
+}
 
-    return strings.TrimSpace(state)
+func IsCemetery(name string) bool {
+    for _, w := range(strings.Fields(name)) {
+        for _, c := range(cemeteryWords) {
+            if w == c {
+                return true
+            }
+        }
+    }
+    return false
 }
 
-func ProcessDatedEvent(words []string) *DatedEvent {
-    var curPos int
+func ProcessBurial(s *Sentence, rec *Record) error {
+    words := WordsAfter(SentenceWords(s.AllWords()), "buried")
 
-    date := new(DatedEvent)
+    burial := new(model.Burial)
 
+    // When the cemetery is named it leads the place, e.g. "in Big Sand
+    // Cemetery, Indian Valley, Floyd Co., VA"
     for pos, w := range(words) {
-        if (w == "on" || w == "in" || w == "about") {
-            var ok bool
+        if w != "in" || pos + 1 >= len(words) || IsDateWord(words[pos + 1]) {
+            continue
+        }
 
-            curPos = pos + 1
-            if date.d.day, ok = IsDay(words[curPos]); ok {
-                curPos += 1
-            }
+        placeToks := strings.SplitN(strings.Join(words[pos + 1:], " "), ",", 2)
 
-            if date.d.month, ok = IsMonth(words[curPos]); ok {
-                curPos += 1
-            }
+        if IsCemetery(placeToks[0]) {
+            burial.Cemetery = strings.TrimSpace(placeToks[0])
 
-            if date.d.year, ok = IsYear(words[curPos]); ok {
-                curPos += 1
-            }
+            dateWords := make([]string, 0)
+            dateWords = append(dateWords, words[:pos]...)
 
-            if !ok {
-                log.Fatalf("Error: expected date but date could not be parsed")
+            if len(placeToks) == 2 {
+                dateWords = append(dateWords, "in")
+                dateWords = append(dateWords, strings.Fields(placeToks[1])...)
             }
 
-            break
+            words = dateWords
         }
+        break
     }
 
-    if words[curPos] == "." {
-        return date
+    date, err := ProcessDatedEvent(words)
+    if err != nil {
+        return err
     }
 
-    if words[curPos] != "in" {
-        log.Fatalf("Error: unexpected word `%s` found when processing location",
-                        words[curPos])
-    }
+    date.Sources = s.Sources()
+    burial.Date = date
+    rec.Burial = burial
 
-    curPos += 1
+    return nil
+}
 
-    // re-assemble end of sentence
-    var locationPart string
-    for pos, w := range(words[curPos:]) {
-        locationPart += w
+func init() {
+    RegisterSentenceHandler(&SentenceHandler{ "baptism", []string{"was baptized"}, 75, ProcessBaptism, nil })
+    RegisterSentenceHandler(&SentenceHandler{ "christening", []string{"was christened"}, 75, ProcessChristening, nil })
+    RegisterSentenceHandler(&SentenceHandler{ "adoption", []string{"was adopted"}, 75, ProcessAdoption, nil })
+}
 
-        // Add a space if its not the last token
-        if pos != len(words[curPos:]) - 1 {
-            locationPart += " "
-        }
+// ProcessBaptism handles "He was baptized on 30 Apr 1805 in Zion Lutheran
+// Church, Floyd Co., VA"
+func ProcessBaptism(s *Sentence, rec *Record) error {
+    baptism, err := ProcessDatedEvent(WordsAfter(s.AllWords(), "baptized"))
+    if err != nil {
+        return err
     }
 
-    locToks := strings.Split(locationPart, ",")
-
-    if len(locToks) > 3 {
-        log.Fatalf("Error: heueristic will not match for `%s`", locationPart)
-    }
+    baptism.Sources = s.Sources()
 
-    // If town, county and state are supplied
-    if len(locToks) == 3 {
-        date.loc.town = locToks[0]
+    rec.Baptism = baptism
 
-        date.loc.county = ParseCounty(locToks[1])
+    return nil
+}
 
-        date.loc.state = ParseState(locToks[2])
+func ProcessChristening(s *Sentence, rec *Record) error {
+    christening, err := ProcessDatedEvent(WordsAfter(s.AllWords(), "christened"))
+    if err != nil {
+        return err
+    }
 
-    } else if len(locToks) == 2 {
-        // County + State
-        date.loc.county = ParseCounty(locToks[0])
+    christening.Sources = s.Sources()
 
-        date.loc.state = ParseState(locToks[1])
+    rec.Christening = christening
 
-    } else {
-        // State
-        //if !strings.HasSuffix(locToks[0], ".") {
-        //    log.Fatalf("Error: state `%s` doesn't end as expected",
-        //                    locToks[0])
-        //}
-        date.loc.state = ParseState(locToks[0])
-    }
-    return date
+    return nil
 }
 
-func main() {
-    dirName := flag.String("d", "", "directory name")
-    //mongoHost := flag.String("t", "", "mongo host")
+// ProcessAdoption handles "She was adopted in 1867" and a bare "She was
+// adopted"
+func ProcessAdoption(s *Sentence, rec *Record) error {
+    adoption, err := ProcessDatedEvent(WordsAfter(s.AllWords(), "adopted"))
+    if err != nil {
+        return err
+    }
 
-    flag.Parse()
+    adoption.Sources = s.Sources()
 
-    //records := make(map[string]*Record, 0)
-    if *dirName == "" {
-        log.Fatal("Error: must specify directory name\n")
+    // "... when he was adopted" restates an adoption already recorded
+    if rec.Adoption != nil && adoption.Date.IsZero() && adoption.Place == (model.Location{}) {
+        return nil
     }
 
-    files, err := ioutil.ReadDir(*dirName)
+    rec.Adoption = adoption
+
+    return nil
+}
+
+func init() {
+    RegisterSentenceHandler(&SentenceHandler{ "military", []string{"served in the military"}, 75, ProcessMilitary, nil })
+}
 
+// militaryWords mark a "place" which actually names the war or the branch
+// of service, e.g. "in Civil War" or "in US Army WWII"
+var militaryWords = []string{"war", "ww", "wwi", "wwii", "korea", "vietnam", "army", "usarmy",
+    "navy", "air", "airforce", "usaf", "force", "forces", "reserves", "marines",
+    "infantry", "cavalry", "artillery"}
+
+// ProcessMilitary handles "He served in the military on 10 Sep 1861 in
+// Pulaski Co., VA" and "He served in the military in Civil War".  Military
+// service has no field of its own, so it's kept as a generic event.
+func ProcessMilitary(s *Sentence, rec *Record) error {
+    service, err := ProcessDatedEvent(WordsAfter(s.AllWords(), "military"))
     if err != nil {
-        log.Fatal(err)
+        return err
     }
 
-    for _, fi := range(files) {
+    service.Sources = s.Sources()
 
-        if !strings.HasSuffix(fi.Name(), ".htm") {
-            continue
+    detail := ""
+    for _, w := range(strings.Fields(strings.Replace(service.Place.String(), ",", " ", -1))) {
+        if hasName(militaryWords, w) {
+            detail = service.Place.String()
+            service.Place = model.Location{}
+            break
         }
+    }
 
-        fmt.Printf("------------ %s -------------\n", fi.Name())
-        htmlText, err := ioutil.ReadFile(path.Join(*dirName, fi.Name()))
+    rec.Events = append(rec.Events, model.NewEvent(model.MilitaryEvent, service, detail))
 
-        if err != nil {
-            log.Fatal(err)
-        }
+    return nil
+}
+
+// ProcessDeath handles "He died on 23 Apr 1888 in Floyd Co., VA" and how the
+// person died, as in "He died of cancer" or "She died in infancy".  A
+// paragraph may say more than once that the person died, so the first date
+// and place found are kept and later sentences only fill in what's missing.
+func ProcessDeath(s *Sentence, rec *Record) error {
+    words := SentenceWords(WordsAfter(s.AllWords(), "died"))
+
+    death := new(model.DatedEvent)
+    cause := ""
+
+    // "in infancy" and "in a car accident" are no place
+    dated := false
+    if len(words) > 0 {
+        _, dated = dateQualifierMap[words[0]]
+    }
+    if dated && len(words) > 1 && !IsDateWord(words[1]) {
+        if r, _ := utf8.DecodeRuneInString(words[1]); unicode.IsLower(r) {
+            dated = false
+        }
+    }
+
+    if dated {
+        var err error
+        death, err = ProcessDatedEvent(words)
+        if err != nil {
+            return err
+        }
+    }
+
+    if death.Date.IsZero() && death.Place.IsZero() {
+        cause = strings.Join(words, " ")
+    }
+
+    added := false
+
+    if rec.Death == nil {
+        rec.Death = new(model.DatedEvent)
+        added = true
+    }
+
+    if rec.Death.Date.IsZero() && !death.Date.IsZero() {
+        rec.Death.Date = death.Date
+        added = true
+    }
+
+    if rec.Death.Place.IsZero() && !death.Place.IsZero() {
+        rec.Death.Place = death.Place
+        added = true
+    }
+
+    if rec.DeathCause == "" && cause != "" {
+        rec.DeathCause = cause
+        added = true
+    }
+
+    if added {
+        rec.Death.Sources = append(rec.Death.Sources, s.Sources()...)
+    }
+
+    return nil
+}
+
+// ProcessMarriageBond handles "This is date of marriage bond for Lewis and
+// a JANE BIRCHFIELD", which says the date given for the marriage is that of
+// the bond, not the wedding.  It must link to or name the spouse, as it may
+// describe another marriage altogether.
+func ProcessMarriageBond(s *Sentence, rec *Record) error {
+    if len(rec.Marriages) == 0 {
+        return NewParseError("marriage bond without a marriage")
+    }
+
+    refs := s.References()
+    names := strings.Split(strings.Join(WordsAfter(SentenceWords(s.AllWords()), "for"), " "), " and ")
+
+    isSpouse := func(m *model.Marriage) bool {
+        for _, f := range(refs) {
+            if m.OtherIdentifier != "" && m.OtherIdentifier == f.RefId {
+                return true
+            }
+        }
+        // "for Lewis and a JANE BIRCHFIELD" names the couple, who must be
+        // this person and the spouse
+        switch len(names) {
+        case 1:
+            return bondNames(names[0], m.OtherName)
+        case 2:
+            return (bondNamesAny(names[0], rec.Names()) && bondNames(names[1], m.OtherName)) ||
+                    (bondNames(names[0], m.OtherName) && bondNamesAny(names[1], rec.Names()))
+        }
+        return false
+    }
+
+    // The marriage just read is the likeliest
+    idx := -1
+    if isSpouse(rec.Marriages[rec.curMarriageIdx]) {
+        idx = rec.curMarriageIdx
+    }
+    for i := 0; idx == -1 && i < len(rec.Marriages); i++ {
+        if isSpouse(rec.Marriages[i]) {
+            idx = i
+        }
+    }
+
+    if idx == -1 {
+        return NewParseError("marriage bond for `%s` names no marriage", strings.Join(names, " and "))
+    }
+
+    m := rec.Marriages[idx]
+    rec.curMarriageIdx = idx
+
+    if m.Date == nil || m.Date.Date.IsZero() {
+        return NewParseError("marriage bond for a marriage without a date")
+    }
+
+    m.Bond = m.Date
+    m.Bond.Sources = append(append([]string{}, m.Bond.Sources...), s.Sources()...)
+    m.Date = nil
+
+    return nil
+}
+
+// bondNames is true if every word of name, besides an article, is one of the
+// spouse's, so "a Jane Blankenship" names Armenita Jane Blankenship but "a
+// JANE BIRCHFIELD" doesn't
+func bondNames(name string, spouse string) bool {
+    spouseWords := model.NameWords(spouse)
+    found := false
+
+    for _, w := range(model.NameWords(name)) {
+        if w == "a" || w == "an" || w == "the" {
+            continue
+        }
+        if !hasName(spouseWords, w) {
+            return false
+        }
+        found = true
+    }
+
+    return found
+}
+
+func bondNamesAny(name string, names []string) bool {
+    for _, n := range(names) {
+        if bondNames(name, n) {
+            return true
+        }
+    }
+    return false
+}
+
+// FindMarriage returns the record's marriage to the given spouse, matched on
+// identifier if one is known and otherwise on name, or nil
+func FindMarriage(rec *Record, identifier string, name string) *model.Marriage {
+    for i, m := range(rec.Marriages) {
+        if (identifier != "" && m.OtherIdentifier == identifier) ||
+            (name != "" && strings.EqualFold(m.OtherName, name)) {
+            rec.curMarriageIdx = i
+            return m
+        }
+    }
+    return nil
+}
+
+// ProcessDivorce handles "She was divorced from Charles S Dulaney before
+// 1930" and a bare "He was divorced", attaching the divorce to the marriage
+// with that spouse.  A divorce from a spouse the record has no marriage to
+// adds the marriage.
+func ProcessDivorce(s *Sentence, rec *Record) error {
+    identifier := ""
+    name := ""
+
+    for _, f := range(s.References()) {
+        identifier = f.RefId
+        name = f.Data
+        break
+    }
+
+    words := SentenceWords(WordsAfter(s.AllWords(), "divorced"))
+    if len(words) > 0 && words[0] == "from" {
+        words = words[1:]
+    }
+
+    // The spouse's name runs up to the date or place
+    pos := 0
+    for ; pos < len(words); pos++ {
+        if marriageDateWords[words[pos]] {
+            break
+        }
+    }
+
+    if name == "" {
+        name = strings.Join(words[:pos], " ")
+    }
+
+    date, err := ProcessDatedEvent(words[pos:])
+    if err != nil {
+        return err
+    }
+
+    date.Sources = s.Sources()
+
+    var m *model.Marriage
+    if identifier != "" || name != "" {
+        m = FindMarriage(rec, identifier, name)
+    } else if len(rec.Marriages) > 0 {
+        m = rec.Marriages[rec.curMarriageIdx]
+    }
+
+    if m == nil {
+        m = model.NewMarriage()
+        m.OtherIdentifier = identifier
+        m.OtherName = name
+        rec.Marriages = append(rec.Marriages, m)
+        rec.curMarriageIdx = len(rec.Marriages) - 1
+    }
+
+    m.Divorce = date
+
+    return nil
+}
+
+// ProcessResidence handles "She resided in 1920 in Floyd Co., VA", along
+// with an address or household ahead of the date, as in "He resided RFD 2
+// in 1930 in Willis, Floyd Co., VA" or "She resided with her sister Lena in
+// 1910 in Covington, KY"
+func ProcessResidence(s *Sentence, rec *Record) error {
+    words := SentenceWords(WordsAfter(s.AllWords(), "resided"))
+
+    pos := datedEventStart(words)
+
+    date, err := ProcessDatedEvent(words[pos:])
+    if err != nil {
+        return err
+    }
+
+    res := &model.Residence{ Date : date }
+    if pos > 0 && words[0] == "with" {
+        res.Household = strings.Join(words[1:pos], " ")
+    } else {
+        res.Address = strings.TrimRight(strings.Join(words[:pos], " "), ",")
+    }
+
+    // A bare "He resided ." says nothing to record
+    if res.Address == "" && res.Household == "" && date.Date.IsZero() && date.Place.IsZero() {
+        return nil
+    }
+
+    date.Sources = s.Sources()
+
+    rec.Residences = append(rec.Residences, res)
+    rec.residenceIdx = len(rec.Residences) - 1
+    rec.lastPlaced = date
+
+    return nil
+}
+
+// ProcessLivingWith handles "She was living with her daughter Chloe E",
+// which follows the census or residence it describes the household of
+func ProcessLivingWith(s *Sentence, rec *Record) error {
+    household := strings.Join(WordsAfter(SentenceWords(s.AllWords()), "with"), " ")
+    household = strings.TrimPrefix(strings.TrimSpace(household), ", ")
+
+    if household == "" {
+        return NewParseError("household is empty")
+    }
+
+    if len(rec.Residences) > 0 && rec.lastPlaced != nil &&
+            rec.Residences[rec.residenceIdx].Date == rec.lastPlaced {
+        rec.Residences[rec.residenceIdx].Household = household
+        return nil
+    }
+
+    date := new(model.DatedEvent)
+    if rec.lastPlaced != nil {
+        *date = *rec.lastPlaced
+    }
+    date.Sources = append(s.Sources(), date.Sources...)
+
+    rec.Residences = append(rec.Residences, &model.Residence{ Date : date, Household : household })
+    rec.residenceIdx = len(rec.Residences) - 1
+    rec.lastPlaced = date
+
+    return nil
+}
+
+var buildWords = map[string]bool {
+    "slender" : true,
+    "stout" : true,
+    "thin" : true,
+    "heavy" : true,
+}
+
+var heightWords = map[string]bool {
+    "tall" : true,
+    "short" : true,
+}
+
+// ParseDescription picks the height, build, eye and hair colour out of a
+// description such as "medium height, medium build, brown eyes and dark hair"
+func ParseDescription(text string) *model.Description {
+    desc := &model.Description{ Text : text }
+
+    clauses := strings.FieldsFunc(text, func(r rune) bool { return r == ',' })
+
+    for _, c := range(clauses) {
+        for _, and := range(strings.Split(c, " and ")) {
+            for _, clause := range(strings.Split(and, " with ")) {
+                clause = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(clause), "."))
+                clauseWords := strings.Fields(clause)
+
+                if len(clauseWords) == 0 {
+                    continue
+                }
+
+                last := clauseWords[len(clauseWords) - 1]
+                rest := strings.Join(clauseWords[:len(clauseWords) - 1], " ")
+
+                if last == "eyes" || last == "eys" {
+                    desc.Eyes = rest
+                } else if last == "hair" {
+                    desc.Hair = rest
+                } else if clause == "bald" {
+                    desc.Hair = clause
+                } else if last == "build" && rest != "" {
+                    desc.Build = strings.TrimPrefix(rest, "a ")
+                } else if buildWords[clause] {
+                    desc.Build = clause
+                } else if last == "height" && rest != "" {
+                    desc.Height = rest
+                } else if heightWords[last] || strings.Contains(clause, "ft") ||
+                        strings.Contains(clause, "'") {
+                    desc.Height = clause
+                } else {
+                    desc.Remarks = append(desc.Remarks, clause)
+                }
+            }
+        }
+    }
+
+    return desc
+}
+
+// ProcessDescription handles "He was described as medium height, medium
+// build, brown eyes and dark hair in 1918 in Basin, Wyoming Co., WV"
+func ProcessDescription(s *Sentence, rec *Record) error {
+    words := WordsAfter(SentenceWords(s.AllWords()), "as")
+
+    // The description runs up to the date or place
+    pos := datedEventStart(words)
+
+    if pos == 0 {
+        return NewParseError("description is empty")
+    }
+
+    date, err := ProcessDatedEvent(words[pos:])
+    if err != nil {
+        return err
+    }
+
+    date.Sources = s.Sources()
+
+    desc := ParseDescription(strings.Join(words[:pos], " "))
+    desc.Date = date
+
+    rec.Descriptions = append(rec.Descriptions, desc)
+
+    return nil
+}
+
+// SentenceHandler records one kind of fact.  A sentence goes to the handler
+// with the highest Priority which has a pattern the sentence contains, so
+// "She was divorced ..." reaches the divorce handler ahead of the more
+// general "died" of a death.  A handler without Process recognises
+// sentences which record nothing, and one with Accepts only takes those
+// sentences it accepts.
+type SentenceHandler struct {
+    Name string
+    Patterns []string
+    Priority int
+    Process func(s *Sentence, rec *Record) error
+    Accepts func(s *Sentence) bool
+}
+
+// Matches reports whether the sentence contains any of the handler's
+// patterns
+func (h *SentenceHandler) Matches(s *Sentence) bool {
+    if h.Accepts != nil && !h.Accepts(s) {
+        return false
+    }
+
+    for _, p := range(h.Patterns) {
+        if s.Contains(p) {
+            return true
+        }
+    }
+    return false
+}
+
+// SaidOfSubject returns a filter for sentences in which the verb is said of
+// the person the sentence starts with, as in "He died ..." or "Luke Graham
+// died ...", rather than of someone in a story such as "... before he died
+// in 1875"
+func SaidOfSubject(verb string) func(s *Sentence) bool {
+    return func(s *Sentence) bool {
+        words := SentenceWords(s.AllWords())
+
+        for i, w := range(words) {
+            if w != verb {
+                continue
+            }
+
+            // "She also died at her son's house"
+            subjectEnd := i
+            if subjectEnd > 1 && words[subjectEnd - 1] == "also" {
+                subjectEnd--
+            }
+
+            if subjectEnd == 0 {
+                return false
+            }
+
+            // Sentences can run together, as after a copied enlistment
+            // record: "... Weight: 128 He died on 20 Aug 2004"
+            if _, ok := pronounGenders[words[subjectEnd - 1]]; ok {
+                return true
+            }
+
+            // Asides such as "He (or she) died ..." are part of the subject
+            aside := false
+            for _, subject := range(words[:subjectEnd]) {
+                if strings.HasPrefix(subject, "(") {
+                    aside = true
+                }
+                if r, _ := utf8.DecodeRuneInString(subject); !aside && !unicode.IsUpper(r) {
+                    return false
+                }
+                if strings.HasSuffix(subject, ")") {
+                    aside = false
+                }
+            }
+            return true
+        }
+
+        return false
+    }
+}
+
+var sentenceHandlers []*SentenceHandler
+
+// RegisterSentenceHandler adds a handler for a new kind of sentence.
+// Handlers of equal priority are tried in the order they were registered.
+func RegisterSentenceHandler(h *SentenceHandler) {
+    sentenceHandlers = append(sentenceHandlers, h)
+
+    sort.SliceStable(sentenceHandlers, func(i, j int) bool {
+        return sentenceHandlers[i].Priority > sentenceHandlers[j].Priority
+    })
+}
+
+// ClassifySentence returns the handler for the sentence, or nil if no
+// handler recognises it
+func ClassifySentence(s *Sentence) *SentenceHandler {
+    for _, h := range(sentenceHandlers) {
+        if h.Matches(s) {
+            return h
+        }
+    }
+    return nil
+}
+
+func init() {
+    RegisterSentenceHandler(&SentenceHandler{ "birth", []string{"was born"}, 140, ProcessBirth, nil })
+    RegisterSentenceHandler(&SentenceHandler{ "census", []string{"appeared on the census"}, 130, ProcessCensus, nil })
+    RegisterSentenceHandler(&SentenceHandler{ "parents", []string{"Parents:"}, 120, ProcessParents, nil })
+    RegisterSentenceHandler(&SentenceHandler{ "children", []string{"Children were:"}, 110, ProcessChildren, nil })
+    RegisterSentenceHandler(&SentenceHandler{ "marriage", []string{"was married"}, 100, ProcessMarriage, nil })
+    RegisterSentenceHandler(&SentenceHandler{ "occupation", []string{"was a ", "was an "}, 90, ProcessOccupation, IsOccupation })
+    RegisterSentenceHandler(&SentenceHandler{ "alias", []string{"also known as"}, 80, ProcessAlias, nil })
+    RegisterSentenceHandler(&SentenceHandler{ "burial", []string{"was buried"}, 70, ProcessBurial, nil })
+    RegisterSentenceHandler(&SentenceHandler{ "divorce", []string{"was divorced"}, 65, ProcessDivorce, nil })
+    RegisterSentenceHandler(&SentenceHandler{ "death", []string{"died"}, 60, ProcessDeath, SaidOfSubject("died") })
+    RegisterSentenceHandler(&SentenceHandler{ "description", []string{"was described as"}, 50, ProcessDescription, nil })
+    RegisterSentenceHandler(&SentenceHandler{ "birth listing", []string{"listed as being born"}, 40, nil, nil })
+    RegisterSentenceHandler(&SentenceHandler{ "marriage bond", []string{"date of marriage bond"}, 30, ProcessMarriageBond, nil })
+    RegisterSentenceHandler(&SentenceHandler{ "residence", []string{"resided"}, 20, ProcessResidence, nil })
+    RegisterSentenceHandler(&SentenceHandler{ "living with", []string{"living with"}, 10, ProcessLivingWith, nil })
+}
+
+// Pronouns which start sentences about the paragraph's person
+var pronounGenders = map[string]model.Gender {
+    "He" : model.Male,
+    "His" : model.Male,
+    "She" : model.Female,
+    "Her" : model.Female,
+}
+
+// PronounGender returns the gender of the pronoun the sentence starts with,
+// as in "She was married to ...".  The paragraph's first sentence starts
+// with the person's name, which is skipped.
+func PronounGender(s *Sentence, first bool) model.Gender {
+    words := SentenceWords(s.AllWords())
+    if first && len(s.Frags) > 0 {
+        if n := len(strings.Fields(s.Frags[0].Data)); n <= len(words) {
+            words = words[n:]
+        }
+    }
+
+    if len(words) == 0 {
+        return model.UnknownGender
+    }
+    return pronounGenders[words[0]]
+}
+
+// UnmatchedSentence is a sentence which no handler recognised, kept so the
+// facts still being missed can be reviewed
+type UnmatchedSentence struct {
+    File string
+    Identifier string
+    Sentence string
+}
+
+func (u *UnmatchedSentence) String() string {
+    str := u.File
+
+    if u.Identifier != "" {
+        str += "#" + u.Identifier
+    }
+
+    return str + ": " + u.Sentence
+}
+
+// GenerateRecords builds a record per paragraph.  A sentence which fails to
+// parse is reported in the returned errors and the rest of the paragraph is
+// still processed.  Sentences no handler recognises are returned as
+// unmatched.
+func GenerateRecords(doc *Document) ([]*model.Record, []*ParseError, []*UnmatchedSentence) {
+
+    records := make([]*model.Record, 0)
+    errs := make([]*ParseError, 0)
+    unmatched := make([]*UnmatchedSentence, 0)
+
+    for _, p := range(doc.Paragraphs) {
+        rec := NewRecord()
+        rec.Identifier = p.Identifier
+        rec.Text = strings.Join(strings.Fields(p.Data), " ")
+        SetHeaderName(&rec.Record, p.Name)
+
+        // A paragraph may mention a spouse's "He", so the person's gender is
+        // the pronoun most of its sentences start with
+        pronouns := make(map[model.Gender]int, 0)
+
+        for i, s := range(p.Sentences) {
+            pronouns[PronounGender(s, i == 0)]++
+
+            h := ClassifySentence(s)
+
+            if h == nil {
+                unmatched = append(unmatched, &UnmatchedSentence{
+                    Identifier : rec.Identifier,
+                    Sentence : s.String(),
+                })
+                continue
+            }
+
+            if h.Process == nil {
+                continue
+            }
+
+            if err := h.Process(s, rec); err != nil {
+                pe := AsParseError(err)
+                pe.Identifier = rec.Identifier
+                pe.Sentence = s.String()
+                errs = append(errs, pe)
+            }
+        }
+        if pronouns[model.Male] > pronouns[model.Female] {
+            rec.Gender = model.Male
+        } else if pronouns[model.Female] > pronouns[model.Male] {
+            rec.Gender = model.Female
+        }
+
+        sort.Stable(model.ByDate(rec.Census))
+        sort.SliceStable(rec.Residences, func(i, j int) bool {
+            return rec.Residences[i].Date.Date.Before(rec.Residences[j].Date.Date)
+        })
+        sort.SliceStable(rec.Occupations, func(i, j int) bool {
+            return rec.Occupations[i].Date.Date.Before(rec.Occupations[j].Date.Date)
+        })
+
+        records = append(records, &rec.Record)
+    }
+
+    return records, errs, unmatched
+}
+
+// FindDuplicates returns pairs of records which may describe the same
+// person: they share a surname, a birth year, middle initial and suffix
+// where both are known, and a given name, counting aliases, so "John E Graham" and his
+// alias "Jno E" match.
+func FindDuplicates(records []*model.Record) [][2]*model.Record {
+    dups := make([][2]*model.Record, 0)
+
+    groups := make(map[string][]*model.Record, 0)
+    keys := make([]string, 0)
+
+    for _, rec := range(records) {
+        if rec.FirstName == "" || rec.LastName == "" {
+            continue
+        }
+
+        key := strings.ToLower(rec.LastName)
+        if _, ok := groups[key]; !ok {
+            keys = append(keys, key)
+        }
+        groups[key] = append(groups[key], rec)
+    }
+
+    sort.Strings(keys)
+
+    for _, key := range(keys) {
+        group := groups[key]
+
+        for i, a := range(group) {
+            for _, b := range(group[i + 1:]) {
+                if a.Identifier == b.Identifier {
+                    continue
+                }
+
+                if a.BirthDate != nil && b.BirthDate != nil &&
+                        a.BirthDate.Date.Year != 0 && b.BirthDate.Date.Year != 0 &&
+                        a.BirthDate.Date.Year != b.BirthDate.Date.Year {
+                    continue
+                }
+
+                // A father and son may share the rest of their name
+                if a.Suffix != "" && b.Suffix != "" &&
+                        !strings.EqualFold(a.Suffix, b.Suffix) {
+                    continue
+                }
+
+                // "William E" may be "William Ernest" but not "William Lewis"
+                if a.MiddleName != "" && b.MiddleName != "" &&
+                        a.MiddleName[0] != b.MiddleName[0] {
+                    continue
+                }
+
+                // Aliases are only matched against the other person's own
+                // name, as two people sharing a nickname says little
+                if hasName(b.GivenNames(), a.FirstName) ||
+                        hasName(a.GivenNames(), b.FirstName) {
+                    dups = append(dups, [2]*model.Record{a, b})
+                }
+            }
+        }
+    }
+
+    return dups
+}
+
+func hasName(names []string, name string) bool {
+    name = strings.ToLower(name)
+
+    for _, n := range(names) {
+        if n == name {
+            return true
+        }
+    }
+    return false
+}
+
+type UnplacedChild struct {
+    Parent *model.Record
+    Child *model.Child
+    Reason string
+}
+
+// FindChildMarriage returns the marriage of rec whose spouse is the other
+// parent listed in the child's own record
+func FindChildMarriage(rec *model.Record, child *model.Record) (*model.Marriage, string) {
+    if child == nil {
+        return nil, "child has no record"
+    }
+
+    var other *model.Parent
+    listed := false
+
+    for _, p := range(child.Parents) {
+        if p == nil {
+            continue
+        }
+
+        if p.Identifier == rec.Identifier {
+            listed = true
+        } else {
+            other = p
+        }
+    }
+
+    if !listed {
+        return nil, "child does not list this parent"
+    }
+
+    if other == nil {
+        return nil, "child lists no other parent"
+    }
+
+    for _, m := range(rec.Marriages) {
+        if m.OtherIdentifier == other.Identifier {
+            return m, ""
+        }
+    }
+
+    return nil, fmt.Sprintf("no marriage to other parent %s (%s)",
+                    other.Name, other.Identifier)
+}
+
+// AssociateChildren is the second pass over all records which moves each
+// entry of Record.Children into the Marriage it was born to, so that every
+// child is listed once.  Children that cannot be placed are left on
+// Record.Children and are returned sorted by parent so they can be reported.
+func AssociateChildren(records map[string]*model.Record) []*UnplacedChild {
+    unplaced := make([]*UnplacedChild, 0)
+
+    ids := make([]string, 0, len(records))
+    for id := range(records) {
+        ids = append(ids, id)
+    }
+    sort.Strings(ids)
+
+    for _, id := range(ids) {
+        rec := records[id]
+
+        left := make([]*model.Child, 0)
+        for _, c := range(rec.Children) {
+            m, reason := FindChildMarriage(rec, records[c.Identifier])
+
+            if m == nil {
+                u := &UnplacedChild{ Parent : rec, Child : c, Reason : reason }
+                unplaced = append(unplaced, u)
+                left = append(left, c)
+                continue
+            }
+
+            m.Children = append(m.Children, c)
+        }
+        rec.Children = left
+    }
+
+    return unplaced
+}
+
+// LabelParents is the second pass over all records which labels each
+// parent as father or mother, moving them so that Parents[0] is the father.
+// People whose own paragraph gives no pronoun take their gender from the
+// side of their children's "Parents: father and mother" lines they appear
+// on.
+func LabelParents(records map[string]*model.Record) {
+    // How often each person is listed first and second of two parents
+    sides := make(map[string][2]int, 0)
+
+    for _, rec := range(records) {
+        if rec.Parents[0] == nil || rec.Parents[1] == nil {
+            continue
+        }
+
+        for i, p := range(rec.Parents) {
+            count := sides[p.Identifier]
+            count[i]++
+            sides[p.Identifier] = count
+        }
+    }
+
+    for id, count := range(sides) {
+        rec, ok := records[id]
+        if !ok || rec.Gender != model.UnknownGender {
+            continue
+        }
+
+        if count[0] > count[1] {
+            rec.Gender = model.Male
+        } else if count[1] > count[0] {
+            rec.Gender = model.Female
+        }
+    }
+
+    for _, rec := range(records) {
+        both := rec.Parents[0] != nil && rec.Parents[1] != nil
+
+        for i, p := range(rec.Parents) {
+            if p == nil {
+                continue
+            }
+
+            if parent, ok := records[p.Identifier]; ok {
+                p.Gender = parent.Gender
+            }
+
+            if p.Gender == model.UnknownGender && both {
+                p.Gender = []model.Gender{model.Male, model.Female}[i]
+            }
+        }
+
+        var genders [2]model.Gender
+        for i, p := range(rec.Parents) {
+            if p != nil {
+                genders[i] = p.Gender
+            }
+        }
+
+        if (genders[0] == model.Female && genders[1] != model.Female) ||
+                (genders[1] == model.Male && genders[0] != model.Male) {
+            rec.Parents[0], rec.Parents[1] = rec.Parents[1], rec.Parents[0]
+        }
+    }
+}
+
+func IsDay(word string) (int, bool) {
+    day, err := strconv.Atoi(word)
+
+    if err != nil {
+        return day, false
+    }
+
+    if day > MAX_MONTH_DAYS {
+        return day, false
+    }
+
+    return day, true
+}
+
+func IsMonth(word string) (time.Month, bool, error) {
+    month, ok := monthMap[word]
+
+    if !ok {
+        w := strings.TrimSuffix(word, ".")
+
+        // We saw a string but it didn't resolve in our month map
+        if !IsDateWord(w) {
+            return time.January, false,
+                    NewParseError("unexpected date element `%s`", w)
+        }
+
+        return time.January, false, nil
+    }
+
+    return month, true, nil
+}
+
+func IsYear(word string) (int, bool) {
+    var year int
+    var err error
+
+    w := strings.TrimSuffix(word, ".")
+
+    if year, err = strconv.Atoi(w); err != nil {
+        return year, false
+    }
+
+    return year, true
+}
+
+// IsDualYear reads a year written together with the next, as in "1917/18",
+// returning both years
+func IsDualYear(word string) (int, int, bool) {
+    toks := strings.Split(strings.TrimSuffix(word, "."), "/")
+    if len(toks) != 2 || len(toks[1]) != 2 {
+        return 0, 0, false
+    }
+
+    year, err := strconv.Atoi(toks[0])
+    if err != nil || year < 100 {
+        return 0, 0, false
+    }
+
+    end, err := strconv.Atoi(toks[1])
+    if err != nil {
+        return 0, 0, false
+    }
+
+    end += year - year % 100
+    if end < year {
+        end += 100
+    }
+    if end != year + 1 {
+        return 0, 0, false
+    }
+
+    return year, end, true
+}
+
+func ParseCounty(words string) string {
+    if !strings.HasSuffix(words, "Co.") {
+        log.Printf("Warning: county `%s` doesn't end as expected",
+                        words)
+    }
+    county := strings.TrimSuffix(words, "Co.")
+
+    return strings.TrimSpace(county)
+}
+
+func ParseState(words string) string {
+    //if !strings.HasSuffix(words, ".") &&
+    //    !strings.HasSuffix(words, " . ") &&
+    //    !strings.HasSuffix(words, " .  ") &&
+    //    !strings.HasSuffix(words, " .") {
+
+    //    log.Fatalf("Error: state `%s` doesn't end as expected",
+    //                    words)
+    //}
+    state := strings.TrimSuffix(words, ".")
+
+    return strings.TrimSpace(state)
+}
+
+// IsDateWord reports whether word can begin a date, i.e. it is a day, a
+// month or a year rather than the first word of a place name
+func IsDateWord(word string) bool {
+    if _, ok := monthMap[word]; ok {
+        return true
+    }
+
+    if _, _, ok := IsDualYear(word); ok {
+        return true
+    }
+
+    _, err := strconv.Atoi(strings.TrimSuffix(word, "."))
+
+    return err == nil
+}
+
+// SentenceWords drops the empty words left behind by citation fragments and
+// stops at the end of the sentence
+func SentenceWords(words []string) []string {
+    sentWords := make([]string, 0)
+
+    for _, w := range(words) {
+        if w == "." {
+            break
+        }
+
+        if w != "" {
+            sentWords = append(sentWords, w)
+        }
+    }
+
+    return sentWords
+}
+
+func ParseLocation(locationPart string) model.Location {
+    var loc model.Location
+
+    locToks := strings.Split(locationPart, ",")
+
+    // State is always last, preceded by the county when there is one.  Any
+    // remaining leading elements (town, district, township) make up the town.
+    last := len(locToks) - 1
+
+    loc.State = ParseState(locToks[last])
+
+    if last >= 1 {
+        loc.County = ParseCounty(locToks[last - 1])
+    }
+
+    if last >= 2 {
+        for pos, t := range(locToks[:last - 1]) {
+            if pos != 0 {
+                loc.Town += ", "
+            }
+            loc.Town += strings.TrimSpace(t)
+        }
+    }
+
+    return loc
+}
+
+// ParseDate reads a day, month and year, any of which but the year may be
+// missing, from the start of words and returns how many words it consumed.
+// A dual year, as in the "1917/18" of draft registrations, gives the range
+// between the two years.  Dates without a year are rejected, including the
+// end of "between 10 Aug 1862 and May", whose year the pages don't give.
+func ParseDate(words []string) (model.Date, int, error) {
+    var d model.Date
+
+    curPos := 0
+
+    if curPos < len(words) {
+        if day, isDay := IsDay(words[curPos]); isDay {
+            d.Day = day
+            curPos += 1
+        }
+    }
+
+    if curPos < len(words) {
+        month, isMonth, err := IsMonth(words[curPos])
+        if err != nil {
+            return d, curPos, err
+        }
+        if isMonth {
+            d.Month = month
+            curPos += 1
+        }
+    }
+
+    isYear := false
+    if curPos < len(words) {
+        var end int
+        if d.Year, isYear = IsYear(words[curPos]); !isYear {
+            d.Year, end, isYear = IsDualYear(words[curPos])
+            if isYear {
+                d.Qualifier = model.Between
+                d.End = &model.Date{ Year : end, Month : d.Month, Day : d.Day }
+            }
+        }
+    }
+
+    if !isYear {
+        return d, curPos, NewParseError("date `%s` has no year", strings.Join(words[:curPos], " "))
+    }
+
+    return d, curPos + 1, nil
+}
+
+func ProcessDatedEvent(words []string) (*model.DatedEvent, error) {
+    var curPos int
+
+    date := new(model.DatedEvent)
+
+    words = SentenceWords(words)
+
+    found := false
+    for pos, w := range(words) {
+        if qualifier, isKey := dateQualifierMap[w]; isKey {
+            var err error
+            var n int
+
+            // "in Virginia" has a place but no date, while "on John Poff's
+            // farm" has neither
+            if pos + 1 >= len(words) || !IsDateWord(words[pos + 1]) {
+                if w == "in" {
+                    found = true
+                    curPos = pos
+                    break
+                }
+                continue
+            }
+
+            found = true
+            curPos = pos + 1
+
+            date.Date, n, err = ParseDate(words[curPos:])
+            curPos += n
+
+            if err != nil {
+                return nil, err
+            }
+
+            // A dual year is already a range
+            if date.Date.End == nil {
+                date.Date.Qualifier = qualifier
+            }
+
+            // "between 1760 and 1770"
+            if qualifier == model.Between {
+                if curPos + 1 >= len(words) || words[curPos] != "and" {
+                    return nil, NewParseError("expected `and` to end date range")
+                }
+
+                end, n, err := ParseDate(words[curPos + 1:])
+                if err != nil {
+                    return nil, err
+                }
+
+                date.Date.End = &end
+                curPos += n + 1
+            }
+
+            break
+        }
+    }
+
+    if !found || curPos >= len(words) {
+        return date, nil
+    }
+
+    if words[curPos] != "in" {
+        return nil, NewParseError("unexpected word `%s` found when processing location",
+                        words[curPos])
+    }
+
+    curPos += 1
+
+    if curPos >= len(words) {
+        return date, nil
+    }
+
+    date.Place = ParseLocation(strings.Join(words[curPos:], " "))
+
+    return date, nil
+}
+
+// Longest line value written before the text is continued with CONC
+const GEDCOM_MAX_VALUE = 200
+
+// Xref of the SUBM record, which GEDCOM 5.5.1 requires HEAD to name
+const GEDCOM_SUBMITTER = "U1"
+
+// The system written as the source of the files WriteGedcom exports
+const GEDCOM_SOURCE = "genealogy"
+
+var gedcomQualifierMap = map[model.DateQualifier]string {
+    model.About : "ABT ",
+    model.Before : "BEF ",
+    model.After : "AFT ",
+}
+
+var gedcomSexMap = map[model.Gender]string {
+    model.Male : "M",
+    model.Female : "F",
+}
+
+func gedcomSimpleDate(d model.Date) string {
+    str := strconv.Itoa(d.Year)
+
+    if d.Month != 0 {
+        str = strings.ToUpper(d.Month.String()[:3]) + " " + str
+    }
+
+    if d.Day != 0 {
+        str = strconv.Itoa(d.Day) + " " + str
+    }
+
+    return str
+}
+
+// GedcomDate formats the date as a GEDCOM 5.5.1 date value, e.g. "ABT 1846"
+// or "BET 1760 AND 1770"
+func GedcomDate(d model.Date) string {
+    if d.IsZero() {
+        return ""
+    }
+
+    if d.Qualifier == model.Between && d.End != nil {
+        return "BET " + gedcomSimpleDate(d) + " AND " + gedcomSimpleDate(*d.End)
+    }
+
+    return gedcomQualifierMap[d.Qualifier] + gedcomSimpleDate(d)
+}
+
+// GedcomPlace lists the jurisdictions of the location from smallest to
+// largest, as GEDCOM expects
+func GedcomPlace(loc model.Location) string {
+    return loc.String()
+}
+
+type gedcomWriter struct {
+    w *bufio.Writer
+    // IDs of the sources cited so far, which each need a SOUR record
+    cited map[string]bool
+}
+
+func (g *gedcomWriter) line(level int, tag string, value string) {
+    if value == "" {
+        fmt.Fprintf(g.w, "%d %s\n", level, tag)
+    } else {
+        fmt.Fprintf(g.w, "%d %s %s\n", level, tag, value)
+    }
+}
+
+// text writes a long value, splitting it over CONC lines
+func (g *gedcomWriter) text(level int, tag string, value string) {
+    for first := true; first || value != ""; first = false {
+        n := len(value)
+        if n > GEDCOM_MAX_VALUE {
+            n = GEDCOM_MAX_VALUE
+
+            // GEDCOM readers drop trailing spaces, so never split at one,
+            // nor inside a character
+            for n > 1 && (value[n - 1] == ' ' || value[n] == ' ' || !utf8.RuneStart(value[n])) {
+                n--
+            }
+        }
+
+        if first {
+            g.line(level, tag, value[:n])
+        } else {
+            g.line(level + 1, "CONC", value[:n])
+        }
+        value = value[n:]
+    }
+}
+
+// event writes an event tag followed by its date and place.  value is only
+// used for events, such as OCCU, which carry one.
+func (g *gedcomWriter) event(tag string, value string, e *model.DatedEvent, place string) {
+    date := ""
+    if e != nil {
+        date = GedcomDate(e.Date)
+        if place == "" {
+            place = GedcomPlace(e.Place)
+        } else if p := GedcomPlace(e.Place); p != "" {
+            place += ", " + p
+        }
+    }
+
+    if value == "" && date == "" && place == "" {
+        value = "Y"
+    }
+
+    g.text(1, tag, value)
+
+    if date != "" {
+        g.line(2, "DATE", date)
+    }
+
+    if place != "" {
+        g.line(2, "PLAC", place)
+    }
+
+    if e != nil {
+        for _, id := range(e.Sources) {
+            g.line(2, "SOUR", gedcomXref("S" + id))
+            g.cited[id] = true
+        }
+    }
+}
+
+func gedcomXref(id string) string {
+    return "@" + id + "@"
+}
+
+type gedcomFamily struct {
+    Xref string
+    Husband string
+    Wife string
+    // Name of a spouse who has no record of their own
+    OtherName string
+    Marriage *model.DatedEvent
+    Bond *model.DatedEvent
+    Divorce *model.DatedEvent
+    Children []string
+}
+
+// GedcomFamilies builds a family for every couple, either married or
+// listed together as parents, keyed on the couple's identifiers.  Each
+// marriage to a spouse without a record is a family of its own.
+func GedcomFamilies(records []*model.Record) []*gedcomFamily {
+    byId := make(map[string]*model.Record, 0)
+    fathers := make(map[string]bool, 0)
+
+    for _, rec := range(records) {
+        byId[rec.Identifier] = rec
+
+        if rec.Parents[0] != nil {
+            fathers[rec.Parents[0].Identifier] = true
+        }
+    }
+
+    families := make(map[string]*gedcomFamily, 0)
+    keys := make([]string, 0)
+
+    isHusband := func(id string) bool {
+        if rec, ok := byId[id]; ok && rec.Gender != model.UnknownGender {
+            return rec.Gender == model.Male
+        }
+        return fathers[id]
+    }
+
+    // n numbers the marriages to spouses without records, which are each a
+    // family of their own, and is 0 for everyone else
+    getFamily := func(a string, b string, n int) *gedcomFamily {
+        // A man, or failing that a person listed first among a child's
+        // parents, is the husband
+        if (isHusband(b) && !isHusband(a)) || (byId[a] != nil && byId[a].Gender == model.Female) {
+            a, b = b, a
+        }
+
+        key := a + "+" + b
+        if b < a {
+            key = b + "+" + a
+        }
+        if n > 0 {
+            key += "#" + strconv.Itoa(n)
+        }
+
+        fam, ok := families[key]
+        if !ok {
+            fam = &gedcomFamily{ Husband : a, Wife : b }
+            families[key] = fam
+            keys = append(keys, key)
+        }
+        return fam
+    }
+
+    hasChild := func(fam *gedcomFamily, id string) bool {
+        for _, c := range(fam.Children) {
+            if c == id {
+                return true
+            }
+        }
+        return false
+    }
+
+    for _, rec := range(records) {
+        for i, m := range(rec.Marriages) {
+            other := m.OtherIdentifier
+            n := 0
+            if _, ok := byId[other]; !ok {
+                other = ""
+                n = i + 1
+            }
+
+            fam := getFamily(rec.Identifier, other, n)
+
+            if other == "" {
+                fam.OtherName = m.OtherName
+            }
+            if fam.Marriage == nil {
+                fam.Marriage = m.Date
+            }
+            if fam.Bond == nil {
+                fam.Bond = m.Bond
+            }
+            if fam.Divorce == nil {
+                fam.Divorce = m.Divorce
+            }
+
+            for _, c := range(m.Children) {
+                if _, ok := byId[c.Identifier]; ok && !hasChild(fam, c.Identifier) {
+                    fam.Children = append(fam.Children, c.Identifier)
+                }
+            }
+        }
+
+        var parents []string
+        for _, p := range(rec.Parents) {
+            if p == nil {
+                continue
+            }
+            if _, ok := byId[p.Identifier]; ok {
+                parents = append(parents, p.Identifier)
+            }
+        }
+
+        if len(parents) == 0 {
+            continue
+        }
+
+        parents = append(parents, "")
+        fam := getFamily(parents[0], parents[1], 0)
+
+        if !hasChild(fam, rec.Identifier) {
+            fam.Children = append(fam.Children, rec.Identifier)
+        }
+    }
+
+    sort.Strings(keys)
+
+    ordered := make([]*gedcomFamily, 0, len(keys))
+    for i, key := range(keys) {
+        fam := families[key]
+        fam.Xref = gedcomXref(fmt.Sprintf("F%d", i + 1))
+        ordered = append(ordered, fam)
+    }
+
+    return ordered
+}
+
+// WriteGedcom exports the records as GEDCOM 5.5.1, using each record's
+// identifier as its INDI xref.  Records without an identifier can't be
+// referenced and are skipped.  Every cited source gets a SOUR record, titled
+// from sources when the source list page was read.
+func WriteGedcom(out io.Writer, records []*model.Record, sources []*model.Source) error {
+    g := &gedcomWriter{ bufio.NewWriter(out), make(map[string]bool, 0) }
+
+    people := make([]*model.Record, 0)
+    for _, rec := range(records) {
+        if rec.Identifier != "" {
+            people = append(people, rec)
+        }
+    }
+
+    families := GedcomFamilies(people)
+
+    g.line(0, "HEAD", "")
+    g.line(1, "SOUR", GEDCOM_SOURCE)
+    g.line(1, "GEDC", "")
+    g.line(2, "VERS", "5.5.1")
+    g.line(2, "FORM", "LINEAGE-LINKED")
+    g.line(1, "CHAR", "UTF-8")
+    g.line(1, "SUBM", gedcomXref(GEDCOM_SUBMITTER))
+
+    g.line(0, gedcomXref(GEDCOM_SUBMITTER), "SUBM")
+    g.line(1, "NAME", GEDCOM_SOURCE)
+
+    for _, rec := range(people) {
+        g.line(0, gedcomXref(rec.Identifier), "INDI")
+
+        given := strings.TrimSpace(rec.FirstName + " " + rec.MiddleName)
+        name := given
+        if rec.LastName != "" {
+            name = strings.TrimSpace(given + " /" + rec.LastName + "/")
+        }
+        if rec.Suffix != "" {
+            name += " " + rec.Suffix
+        }
+        g.text(1, "NAME", name)
+        if rec.Title != "" {
+            g.line(2, "NPFX", rec.Title)
+        }
+        if given != "" {
+            g.line(2, "GIVN", given)
+        }
+        if rec.LastName != "" {
+            g.line(2, "SURN", rec.LastName)
+        }
+        if rec.Suffix != "" {
+            g.line(2, "NSFX", rec.Suffix)
+        }
+
+        for _, a := range(rec.Aliases) {
+            g.text(1, "NAME", a)
+            g.line(2, "TYPE", "aka")
+        }
+
+        if sex, ok := gedcomSexMap[rec.Gender]; ok {
+            g.line(1, "SEX", sex)
+        }
+
+        if rec.BirthDate != nil {
+            g.event("BIRT", "", rec.BirthDate, "")
+        }
+
+        if rec.Baptism != nil {
+            g.event("BAPM", "", rec.Baptism, "")
+        }
+
+        if rec.Christening != nil {
+            g.event("CHR", "", rec.Christening, "")
+        }
+
+        if rec.Adoption != nil {
+            g.event("ADOP", "", rec.Adoption, "")
+        }
+
+        if rec.Death != nil {
+            g.event("DEAT", "", rec.Death, "")
+
+            if rec.DeathCause != "" {
+                g.line(2, "CAUS", rec.DeathCause)
+            }
+        }
+
+        if rec.Burial != nil {
+            g.event("BURI", "", rec.Burial.Date, rec.Burial.Cemetery)
+        }
+
+        for _, c := range(rec.Census) {
+            g.event("CENS", "", c, "")
+        }
+
+        for _, o := range(rec.Occupations) {
+            g.event("OCCU", o.Name, o.Date, "")
+        }
+
+        for _, r := range(rec.Residences) {
+            g.event("RESI", "", r.Date, "")
+
+            if r.Address != "" {
+                g.text(2, "ADDR", r.Address)
+            }
+
+            if r.Household != "" {
+                g.text(2, "NOTE", "Living with " + r.Household)
+            }
+        }
+
+        for _, d := range(rec.Descriptions) {
+            g.event("DSCR", d.Text, d.Date, "")
+        }
+
+        // Events with no tag of their own are written generically, with
+        // their type given by TYPE
+        for _, e := range(rec.Events) {
+            g.event("EVEN", e.Detail, &e.DatedEvent, "")
+            g.line(2, "TYPE", string(e.Type))
+        }
+
+        if rec.Text != "" {
+            g.text(1, "NOTE", rec.Text)
+        }
+
+        for _, fam := range(families) {
+            if fam.Husband == rec.Identifier || fam.Wife == rec.Identifier {
+                g.line(1, "FAMS", fam.Xref)
+            }
+        }
+
+        for _, fam := range(families) {
+            for _, c := range(fam.Children) {
+                if c == rec.Identifier {
+                    g.line(1, "FAMC", fam.Xref)
+                }
+            }
+        }
+    }
+
+    for _, fam := range(families) {
+        g.line(0, fam.Xref, "FAM")
+
+        if fam.Husband != "" {
+            g.line(1, "HUSB", gedcomXref(fam.Husband))
+        }
+
+        if fam.Wife != "" {
+            g.line(1, "WIFE", gedcomXref(fam.Wife))
+        }
+
+        if fam.Bond != nil {
+            g.event("MARB", "", fam.Bond, "")
+        }
+
+        if fam.Marriage != nil {
+            g.event("MARR", "", fam.Marriage, "")
+        }
+
+        if fam.Divorce != nil {
+            g.event("DIV", "", fam.Divorce, "")
+        }
+
+        if fam.OtherName != "" {
+            g.text(1, "NOTE", "Spouse: " + fam.OtherName)
+        }
+
+        for _, c := range(fam.Children) {
+            g.line(1, "CHIL", gedcomXref(c))
+        }
+    }
+
+    sourceText := make(map[string]string, 0)
+    for _, src := range(sources) {
+        sourceText[src.Identifier] = src.Text
+    }
+
+    cited := make([]string, 0, len(g.cited))
+    for id := range(g.cited) {
+        cited = append(cited, id)
+    }
+    sort.Strings(cited)
+
+    for _, id := range(cited) {
+        g.line(0, gedcomXref("S" + id), "SOUR")
+
+        if text := sourceText[id]; text != "" {
+            g.text(1, "TITL", text)
+        }
+    }
+
+    g.line(0, "TRLR", "")
+
+    return g.w.Flush()
+}
+
+// A gedcomLine is one line of a GEDCOM file with the lines nested beneath it
+type gedcomLine struct {
+    Level int
+    Xref string
+    Tag string
+    Value string
+    Number int
+    Subs []*gedcomLine
+}
+
+// Sub returns the first line nested directly beneath l with the given tag
+func (l *gedcomLine) Sub(tag string) *gedcomLine {
+    for _, s := range(l.Subs) {
+        if s.Tag == tag {
+            return s
+        }
+    }
+    return nil
+}
+
+func (l *gedcomLine) SubValue(tag string) string {
+    if s := l.Sub(tag); s != nil {
+        return s.Value
+    }
+    return ""
+}
+
+// Text returns the value of l joined with its CONC and CONT continuations
+func (l *gedcomLine) Text() string {
+    text := l.Value
+
+    for _, s := range(l.Subs) {
+        if s.Tag == "CONC" {
+            text += s.Value
+        } else if s.Tag == "CONT" {
+            text += "\n" + s.Value
+        }
+    }
+
+    return text
+}
+
+func stripXref(xref string) string {
+    return strings.TrimSuffix(strings.TrimPrefix(xref, "@"), "@")
+}
+
+// ParseGedcomLines reads a GEDCOM file into its level 0 records
+func ParseGedcomLines(r io.Reader) ([]*gedcomLine, []*ParseError) {
+    top := make([]*gedcomLine, 0)
+    errs := make([]*ParseError, 0)
+
+    // stack[n] is the most recent line at level n
+    stack := make([]*gedcomLine, 0)
+
+    scanner := bufio.NewScanner(r)
+    number := 0
+
+    for scanner.Scan() {
+        number++
+
+        text := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+        if text == "" {
+            continue
+        }
+
+        toks := strings.SplitN(text, " ", 3)
+
+        level, err := strconv.Atoi(toks[0])
+        if err != nil || len(toks) < 2 || level > len(stack) {
+            pe := NewParseError("line %d is not a valid GEDCOM line", number)
+            pe.Sentence = text
+            errs = append(errs, pe)
+            continue
+        }
+
+        l := &gedcomLine{ Level : level, Number : number }
+
+        toks = toks[1:]
+        if strings.HasPrefix(toks[0], "@") && len(toks) > 1 {
+            l.Xref = stripXref(toks[0])
+            toks = strings.SplitN(toks[1], " ", 2)
+        }
+
+        l.Tag = toks[0]
+        if len(toks) > 1 {
+            l.Value = toks[1]
+        }
+
+        stack = append(stack[:level], l)
+
+        if level == 0 {
+            top = append(top, l)
+        } else {
+            parent := stack[level - 1]
+            parent.Subs = append(parent.Subs, l)
+        }
+    }
+
+    if err := scanner.Err(); err != nil {
+        errs = append(errs, AsParseError(err))
+    }
+
+    return top, errs
+}
+
+// ParseGedcomDate reads a GEDCOM date value such as "4 SEP 1896",
+// "ABT 1846" or "BET 1760 AND 1770"
+func ParseGedcomDate(value string) (model.Date, error) {
+    var d model.Date
+
+    words := strings.Fields(value)
+
+    // Month names are upper case in GEDCOM but title case in the pages
+    for i, w := range(words) {
+        if len(w) == 3 {
+            words[i] = w[:1] + strings.ToLower(w[1:])
+        }
+    }
+
+    if len(words) == 0 {
+        return d, nil
+    }
+
+    qualifier := model.Exact
+    switch words[0] {
+    case "Abt", "Est", "Cal":
+        qualifier = model.About
+    case "Bef":
+        qualifier = model.Before
+    case "Aft":
+        qualifier = model.After
+    case "Bet":
+        qualifier = model.Between
+    }
+
+    if qualifier != model.Exact {
+        words = words[1:]
+    }
+
+    if len(words) == 0 {
+        return d, NewParseError("date `%s` has no value", value)
+    }
+
+    d, n, err := ParseDate(words)
+    if err != nil {
+        return d, err
+    }
+
+    d.Qualifier = qualifier
+
+    if qualifier == model.Between {
+        if n + 1 >= len(words) || words[n] != "And" {
+            return d, NewParseError("expected `AND` to end date range in `%s`", value)
+        }
+
+        end, _, err := ParseDate(words[n + 1:])
+        if err != nil {
+            return d, err
+        }
+
+        d.End = &end
+    }
+
+    return d, nil
+}
+
+// ParseGedcomPlace splits a GEDCOM place, which lists jurisdictions from
+// smallest to largest, into town, county and state
+func ParseGedcomPlace(value string) model.Location {
+    var loc model.Location
+
+    placeToks := make([]string, 0)
+    for _, t := range(strings.Split(value, ",")) {
+        if t = strings.TrimSpace(t); t != "" {
+            placeToks = append(placeToks, t)
+        }
+    }
+
+    last := len(placeToks) - 1
+
+    if last >= 0 {
+        loc.State = placeToks[last]
+    }
+
+    if last >= 1 {
+        loc.County = strings.TrimSpace(strings.TrimSuffix(placeToks[last - 1], "Co."))
+    }
+
+    if last >= 2 {
+        loc.Town = strings.Join(placeToks[:last - 1], ", ")
+    }
+
+    return loc
+}
+
+func gedcomEvent(l *gedcomLine) (*model.DatedEvent, error) {
+    e := new(model.DatedEvent)
+
+    d, err := ParseGedcomDate(l.SubValue("DATE"))
+    if err != nil {
+        return nil, err
+    }
+
+    e.Date = d
+    e.Place = ParseGedcomPlace(l.SubValue("PLAC"))
+
+    for _, s := range(l.Subs) {
+        if s.Tag == "SOUR" && strings.HasPrefix(s.Value, "@") {
+            e.Sources = append(e.Sources, strings.TrimPrefix(stripXref(s.Value), "S"))
+        }
+    }
+
+    return e, nil
+}
+
+// SetGedcomName fills in the record's name from a GEDCOM NAME value, in
+// which the surname is delimited by slashes and followed by any suffix, e.g.
+// "John Edward /Graham/ JR"
+func SetGedcomName(rec *model.Record, value string) {
+    given := value
+    surname := ""
+
+    if start := strings.Index(value, "/"); start != -1 {
+        given = value[:start]
+        rest := value[start + 1:]
+
+        if end := strings.Index(rest, "/"); end != -1 {
+            surname = rest[:end]
+            rec.Suffix = strings.TrimSpace(rest[end + 1:])
+        } else {
+            surname = rest
+        }
+    }
+
+    givenWords := strings.Fields(given)
+
+    if len(givenWords) > 0 {
+        rec.FirstName = givenWords[0]
+        rec.MiddleName = strings.Join(givenWords[1:], " ")
+    }
+    rec.LastName = strings.TrimSpace(surname)
+}
+
+func processGedcomIndividual(indi *gedcomLine) (*model.Record, []*ParseError) {
+    rec := model.NewRecord()
+    rec.Identifier = indi.Xref
+
+    errs := make([]*ParseError, 0)
+    named := false
+
+    for _, l := range(indi.Subs) {
+        var e *model.DatedEvent
+        var err error
+
+        switch l.Tag {
+        case "BIRT", "BAPM", "CHR", "ADOP", "DEAT", "BURI", "CENS", "OCCU", "RESI", "DSCR", "EVEN":
+            e, err = gedcomEvent(l)
+        }
+
+        if err != nil {
+            pe := AsParseError(err)
+            pe.Identifier = rec.Identifier
+            pe.Sentence = fmt.Sprintf("line %d: %s", l.Number, l.Tag)
+            errs = append(errs, pe)
+            continue
+        }
+
+        switch l.Tag {
+        case "NAME":
+            // Only the first NAME is the primary name, the rest are aliases
+            if !named {
+                SetGedcomName(rec, l.Text())
+                rec.Title = l.SubValue("NPFX")
+                named = true
+            } else {
+                alias := strings.Join(strings.Fields(strings.Replace(l.Text(), "/", " ", -1)), " ")
+                rec.Aliases = append(rec.Aliases, alias)
+            }
+        case "SEX":
+            if l.Value == "F" {
+                rec.Gender = model.Female
+            } else if l.Value == "M" {
+                rec.Gender = model.Male
+            }
+        case "BIRT":
+            rec.BirthDate = e
+        case "BAPM":
+            rec.Baptism = e
+        case "CHR":
+            rec.Christening = e
+        case "ADOP":
+            rec.Adoption = e
+        case "DEAT":
+            rec.Death = e
+            rec.DeathCause = l.SubValue("CAUS")
+        case "BURI":
+            burial := &model.Burial{ Date : e }
+
+            // The cemetery, when there is one, leads the place
+            placeToks := strings.SplitN(l.SubValue("PLAC"), ",", 2)
+            if IsCemetery(placeToks[0]) {
+                burial.Cemetery = strings.TrimSpace(placeToks[0])
+                if len(placeToks) == 2 {
+                    e.Place = ParseGedcomPlace(placeToks[1])
+                } else {
+                    e.Place = model.Location{}
+                }
+            }
+
+            rec.Burial = burial
+        case "CENS":
+            rec.Census = append(rec.Census, e)
+        case "DSCR":
+            desc := ParseDescription(l.Text())
+            desc.Date = e
+            rec.Descriptions = append(rec.Descriptions, desc)
+        case "OCCU":
+            rec.Occupations = append(rec.Occupations,
+                    &model.Occupation{ Name : l.Text(), Date : e })
+        case "RESI":
+            r := &model.Residence{ Date : e }
+            if addr := l.Sub("ADDR"); addr != nil {
+                r.Address = addr.Text()
+            }
+            if note := l.Sub("NOTE"); note != nil {
+                r.Household = strings.TrimPrefix(note.Text(), "Living with ")
+            }
+            rec.Residences = append(rec.Residences, r)
+        case "EVEN":
+            detail := l.Text()
+            if detail == "Y" {
+                detail = ""
+            }
+            rec.Events = append(rec.Events,
+                    model.NewEvent(model.EventType(l.SubValue("TYPE")), e, detail))
+        case "NOTE":
+            if rec.Text != "" {
+                rec.Text += " "
+            }
+            rec.Text += l.Text()
+        }
+    }
+
+    sort.Stable(model.ByDate(rec.Census))
+
+    return rec, errs
+}
+
+// prefixXrefs puts prefix in front of every xref and pointer in the lines
+func prefixXrefs(lines []*gedcomLine, prefix string) {
+    for _, l := range(lines) {
+        if l.Xref != "" {
+            l.Xref = prefix + l.Xref
+        }
+
+        if len(l.Value) > 2 && strings.HasPrefix(l.Value, "@") && strings.HasSuffix(l.Value, "@") {
+            l.Value = gedcomXref(prefix + stripXref(l.Value))
+        }
+
+        prefixXrefs(l.Subs, prefix)
+    }
+}
+
+// GedcomPrefix gives the prefix for the xrefs of a GEDCOM file, made from
+// the letters and digits of its name, e.g. "smith_" for smith-family.ged
+func GedcomPrefix(fileName string) string {
+    stem := strings.TrimSuffix(path.Base(fileName), path.Ext(fileName))
+
+    prefix := strings.Map(func(r rune) rune {
+        if unicode.IsLetter(r) || unicode.IsDigit(r) {
+            return r
+        }
+        return -1
+    }, stem)
+
+    return prefix + "_"
+}
+
+// ReadGedcom imports a GEDCOM file into records.  INDI xrefs become record
+// identifiers and each FAM links its spouses through Marriages and
+// Parents; children are left for AssociateChildren to place in the
+// marriage, as they are for the HTML pages.  SOUR records are returned as
+// sources.
+//
+// Files from other programs nearly all number their people from @I1@, so
+// their xrefs are given prefix to keep apart the people of different files.
+// The xrefs of files written by WriteGedcom are already the identifiers of
+// the people they were written from, and are kept.
+func ReadGedcom(r io.Reader, prefix string) ([]*model.Record, []*model.Source, []*ParseError) {
+    top, errs := ParseGedcomLines(r)
+
+    if len(top) == 0 || top[0].Tag != "HEAD" || top[0].SubValue("SOUR") != GEDCOM_SOURCE {
+        prefixXrefs(top, prefix)
+    }
+
+    records := make([]*model.Record, 0)
+    sources := make([]*model.Source, 0)
+    byId := make(map[string]*model.Record, 0)
+
+    for _, l := range(top) {
+        if l.Tag == "SOUR" {
+            text := l.SubValue("TITL")
+            if titl := l.Sub("TITL"); titl != nil {
+                text = titl.Text()
+            }
+
+            src := &model.Source{ Identifier : strings.TrimPrefix(l.Xref, "S"), Text : text }
+            sources = append(sources, src)
+        }
+
+        if l.Tag != "INDI" {
+            continue
+        }
+
+        rec, recErrs := processGedcomIndividual(l)
+
+        records = append(records, rec)
+        byId[rec.Identifier] = rec
+        errs = append(errs, recErrs...)
+    }
+
+    for _, l := range(top) {
+        if l.Tag != "FAM" {
+            continue
+        }
+
+        husband := byId[stripXref(l.SubValue("HUSB"))]
+        wife := byId[stripXref(l.SubValue("WIFE"))]
+
+        familyEvent := func(tag string) *model.DatedEvent {
+            m := l.Sub(tag)
+            if m == nil {
+                return nil
+            }
+
+            e, err := gedcomEvent(m)
+            if err != nil {
+                pe := AsParseError(err)
+                pe.Identifier = l.Xref
+                pe.Sentence = fmt.Sprintf("line %d: %s", m.Number, tag)
+                errs = append(errs, pe)
+            }
+            return e
+        }
+
+        marriage := familyEvent("MARR")
+        bond := familyEvent("MARB")
+        divorce := familyEvent("DIV")
+
+        spouses := []*model.Record{husband, wife}
+        for i, rec := range(spouses) {
+            other := spouses[1 - i]
+
+            if rec == nil {
+                continue
+            }
+
+            m := model.NewMarriage()
+            m.Date = marriage
+            m.Bond = bond
+            m.Divorce = divorce
+            if other != nil {
+                m.OtherIdentifier = other.Identifier
+                m.OtherName = other.FullName()
+            } else if note := l.SubValue("NOTE"); strings.HasPrefix(note, "Spouse: ") {
+                m.OtherName = strings.TrimPrefix(note, "Spouse: ")
+            }
+
+            // A FAM without a spouse or marriage only records parentage
+            if other != nil || marriage != nil || divorce != nil {
+                rec.Marriages = append(rec.Marriages, m)
+            }
+        }
+
+        for _, c := range(l.Subs) {
+            if c.Tag != "CHIL" {
+                continue
+            }
+
+            child := byId[stripXref(c.Value)]
+            if child == nil {
+                pe := NewParseError("family child `%s` has no INDI record", c.Value)
+                pe.Identifier = l.Xref
+                errs = append(errs, pe)
+                continue
+            }
+
+            for i, rec := range(spouses) {
+                if rec == nil {
+                    continue
+                }
+
+                child.Parents[i] = &model.Parent{ Identifier : rec.Identifier,
+                                            Name : rec.FullName() }
+                rec.Children = append(rec.Children,
+                        &model.Child{ Identifier : child.Identifier, Name : child.FullName() })
+            }
+        }
+    }
+
+    return records, sources, errs
+}
+
+// ProcessSourceDocument reads the fowsrc.htm source list page, in which the
+// text of each source follows an <A NAME="629"> anchor
+func ProcessSourceDocument(n *html.Node) []*model.Source {
+    sources := make([]*model.Source, 0)
+
+    var cur *model.Source
+
+    var walk func(n *html.Node)
+    walk = func(n *html.Node) {
+        if n.Type == html.ElementNode && n.Data == "head" {
+            return
+        }
+
+        if n.Type == html.ElementNode && n.Data == "a" {
+            for _, a := range(n.Attr) {
+                if a.Key == "name" {
+                    cur = &model.Source{ Identifier : strings.TrimSpace(a.Val) }
+                    sources = append(sources, cur)
+                }
+            }
+        } else if n.Type == html.TextNode && cur != nil {
+            cur.Text += n.Data + " "
+        }
+
+        for c := n.FirstChild; c != nil; c = c.NextSibling {
+            walk(c)
+        }
+    }
+
+    walk(n)
+
+    for _, src := range(sources) {
+        src.Text = strings.Join(strings.Fields(src.Text), " ")
+    }
+
+    return sources
+}
+
+// Upserter is the part of a collection that storing needs, which
+// *mgo.Collection provides
+type Upserter interface {
+    Upsert(selector interface{}, update interface{}) (*mgo.ChangeInfo, error)
+}
+
+func StoreSources(c Upserter, sources []*model.Source) error {
+    for _, src := range(sources) {
+        _, err := c.Upsert(bson.M{"identifier" : src.Identifier}, src)
+
+        if err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+// StoreRecords upserts each record into the collection, keyed on its
+// identifier, so that re-running ingest over the same pages replaces the
+// people written by the previous run rather than duplicating them.
+func StoreRecords(c Upserter, records []*model.Record) error {
+    for _, rec := range(records) {
+        if rec.Identifier == "" {
+            log.Printf("Warning: skipping `%s` which has no identifier",
+                            rec.Text)
+            continue
+        }
+
+        rec.SetNameWords()
+        _, err := c.Upsert(bson.M{"identifier" : rec.Identifier}, rec)
+
+        if err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+// Chart box sizes and the gaps between them, in SVG pixels
+const CHART_BOX_WIDTH = 180
+const CHART_BOX_HEIGHT = 44
+const CHART_H_GAP = 16
+const CHART_V_GAP = 40
+
+// ChartNode is a person in a pedigree or descendant chart.  Related holds
+// the next generation out from the root: the parents in a pedigree and the
+// children in a descendant chart.  A person reached a second time, as when
+// cousins marry, appears again but their relatives are only followed once.
+type ChartNode struct {
+    Rec *model.Record
+    Generation int
+    // Spouses are drawn beside the person in descendant charts
+    Spouses []*model.Record
+    Related []*ChartNode
+}
+
+// BuildChart follows the parents, or with descendants the children, of the
+// root person out to depth generations
+func BuildChart(records map[string]*model.Record, root string, depth int, descendants bool) (*ChartNode, error) {
+    if depth < 0 {
+        return nil, fmt.Errorf("negative depth %d", depth)
+    }
+
+    rec, ok := records[root]
+    if !ok {
+        return nil, fmt.Errorf("no record `%s`", root)
+    }
+
+    seen := make(map[string]bool, 0)
+
+    var build func(rec *model.Record, gen int) *ChartNode
+    build = func(rec *model.Record, gen int) *ChartNode {
+        n := &ChartNode{ Rec : rec, Generation : gen }
+
+        if seen[rec.Identifier] {
+            return n
+        }
+        seen[rec.Identifier] = true
+
+        if descendants {
+            for _, m := range(rec.Marriages) {
+                if spouse, ok := records[m.OtherIdentifier]; ok {
+                    n.Spouses = append(n.Spouses, spouse)
+                } else if m.OtherName != "" {
+                    spouse := model.NewRecord()
+                    SetHeaderName(spouse, m.OtherName)
+                    n.Spouses = append(n.Spouses, spouse)
+                }
+            }
+        }
+
+        if gen == depth {
+            return n
+        }
+
+        if descendants {
+            for _, c := range(rec.AllChildren()) {
+                if child, ok := records[c.Identifier]; ok {
+                    n.Related = append(n.Related, build(child, gen + 1))
+                }
+            }
+        } else {
+            for _, p := range(rec.Parents) {
+                if p == nil {
+                    continue
+                }
+                if parent, ok := records[p.Identifier]; ok {
+                    n.Related = append(n.Related, build(parent, gen + 1))
+                }
+            }
+        }
+
+        return n
+    }
+
+    return build(rec, 0), nil
+}
+
+// lifespan gives the years of birth and death, e.g. "1850 - 1920"
+func lifespan(rec *model.Record) string {
+    year := func(e *model.DatedEvent) string {
+        if e == nil || e.Date.Year == 0 {
+            return ""
+        }
+        return strconv.Itoa(e.Date.Year)
+    }
+
+    born := year(rec.BirthDate)
+    died := year(rec.Death)
+
+    switch {
+    case born != "" && died != "":
+        return born + " - " + died
+    case born != "":
+        return "b. " + born
+    case died != "":
+        return "d. " + died
+    }
+    return ""
+}
+
+// chartName labels a person's box, titles included
+func chartName(rec *model.Record) string {
+    name := strings.TrimSpace(rec.Title + " " + rec.FullName())
+    if name == "" {
+        return "Unknown"
+    }
+    return name
+}
+
+var chartGenderColors = map[model.Gender]string {
+    model.UnknownGender : "#eeeeee",
+    model.Male : "#d6e4f5",
+    model.Female : "#f7dbe4",
+}
+
+// WriteDot writes the chart as a Graphviz digraph with an edge from each
+// parent to their child, so that `dot -Tsvg` draws ancestors above
+func WriteDot(out io.Writer, root *ChartNode, descendants bool) error {
+    w := bufio.NewWriter(out)
+
+    nodes := make(map[*model.Record]string, 0)
+    nodeId := func(rec *model.Record) string {
+        if id, ok := nodes[rec]; ok {
+            return id
+        }
+
+        id := rec.Identifier
+        if id == "" {
+            id = fmt.Sprintf("spouse%d", len(nodes))
+        }
+        nodes[rec] = id
+
+        label := chartName(rec)
+        if span := lifespan(rec); span != "" {
+            label += "\n" + span
+        }
+        fmt.Fprintf(w, "    %s [label=%s, fillcolor=%s];\n", strconv.Quote(id),
+                        strconv.Quote(label), strconv.Quote(chartGenderColors[rec.Gender]))
+        return id
+    }
+
+    edges := make(map[string]bool, 0)
+    edge := func(from string, to string, attrs string) {
+        e := fmt.Sprintf("    %s -> %s%s;\n", strconv.Quote(from), strconv.Quote(to), attrs)
+        if !edges[e] {
+            edges[e] = true
+            fmt.Fprint(w, e)
+        }
+    }
+
+    var walk func(n *ChartNode)
+    walk = func(n *ChartNode) {
+        id := nodeId(n.Rec)
+
+        for _, s := range(n.Spouses) {
+            spouse := nodeId(s)
+            edge(id, spouse, " [dir=none, style=dashed, constraint=false]")
+            fmt.Fprintf(w, "    { rank=same; %s; %s; }\n", strconv.Quote(id), strconv.Quote(spouse))
+        }
+
+        for _, r := range(n.Related) {
+            related := nodeId(r.Rec)
+            if descendants {
+                edge(id, related, "")
+            } else {
+                edge(related, id, "")
+            }
+            walk(r)
+        }
+    }
+
+    name := "pedigree"
+    if descendants {
+        name = "descendants"
+    }
+
+    fmt.Fprintf(w, "digraph %s {\n", name)
+    fmt.Fprintf(w, "    node [shape=box, style=\"rounded,filled\", fontname=\"Helvetica\"];\n")
+    walk(root)
+    fmt.Fprintf(w, "}\n")
+
+    return w.Flush()
+}
+
+var svgEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\"", "&quot;")
+
+type chartBox struct {
+    node *ChartNode
+    // Left edge of the person's own box, in box widths
+    x float64
+    children []*chartBox
+}
+
+// layoutChart gives each subtree as many columns as its widest generation
+// needs and centres each person over their related boxes.  It returns the
+// number of columns the subtree takes.
+func layoutChart(n *ChartNode, left float64, boxes *[]*chartBox) (*chartBox, float64) {
+    b := &chartBox{ node : n }
+    *boxes = append(*boxes, b)
+
+    own := float64(1 + len(n.Spouses))
+
+    width := 0.0
+    for _, r := range(n.Related) {
+        c, w := layoutChart(r, left + width, boxes)
+        b.children = append(b.children, c)
+        width += w
+    }
+
+    if width < own {
+        // Centre the related boxes under a wider family
+        shift := (own - width) / 2
+        for _, c := range(b.children) {
+            shiftChart(c, shift)
+        }
+        width = own
+    }
+
+    b.x = left + (width - own) / 2
+
+    return b, width
+}
+
+func shiftChart(b *chartBox, dx float64) {
+    b.x += dx
+    for _, c := range(b.children) {
+        shiftChart(c, dx)
+    }
+}
+
+// WriteSvg draws the chart as SVG without needing Graphviz.  Pedigrees are
+// drawn with the root at the bottom and descendant charts with it at the
+// top.
+func WriteSvg(out io.Writer, root *ChartNode, descendants bool) error {
+    w := bufio.NewWriter(out)
+
+    boxes := make([]*chartBox, 0)
+    top, columns := layoutChart(root, 0, &boxes)
+
+    generations := 0
+    for _, b := range(boxes) {
+        if b.node.Generation > generations {
+            generations = b.node.Generation
+        }
+    }
+
+    colX := func(x float64) float64 {
+        return x * (CHART_BOX_WIDTH + CHART_H_GAP) + CHART_H_GAP
+    }
+    rowY := func(gen int) float64 {
+        if !descendants {
+            gen = generations - gen
+        }
+        return float64(gen * (CHART_BOX_HEIGHT + CHART_V_GAP) + CHART_V_GAP / 2)
+    }
+
+    width := colX(columns)
+    height := float64((generations + 1) * (CHART_BOX_HEIGHT + CHART_V_GAP))
+
+    fmt.Fprintf(w, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+    fmt.Fprintf(w, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%.0f\" height=\"%.0f\" font-family=\"Helvetica\" font-size=\"12\">\n",
+                    width, height)
+
+    drawBox := func(rec *model.Record, x float64, y float64) {
+        fmt.Fprintf(w, "<rect x=\"%.0f\" y=\"%.0f\" width=\"%d\" height=\"%d\" rx=\"6\" fill=\"%s\" stroke=\"#555555\"/>\n",
+                        x, y, CHART_BOX_WIDTH, CHART_BOX_HEIGHT, chartGenderColors[rec.Gender])
+        fmt.Fprintf(w, "<text x=\"%.0f\" y=\"%.0f\" text-anchor=\"middle\">%s</text>\n",
+                        x + CHART_BOX_WIDTH / 2, y + 18, svgEscaper.Replace(chartName(rec)))
+        if span := lifespan(rec); span != "" {
+            fmt.Fprintf(w, "<text x=\"%.0f\" y=\"%.0f\" text-anchor=\"middle\" fill=\"#555555\">%s</text>\n",
+                            x + CHART_BOX_WIDTH / 2, y + 34, span)
+        }
+    }
+
+    var draw func(b *chartBox)
+    draw = func(b *chartBox) {
+        x := colX(b.x)
+        y := rowY(b.node.Generation)
+        drawBox(b.node.Rec, x, y)
+
+        for i, s := range(b.node.Spouses) {
+            sx := colX(b.x + float64(i + 1))
+            fmt.Fprintf(w, "<line x1=\"%.0f\" y1=\"%.0f\" x2=\"%.0f\" y2=\"%.0f\" stroke=\"#555555\" stroke-dasharray=\"4,3\"/>\n",
+                            sx - CHART_H_GAP, y + CHART_BOX_HEIGHT / 2, sx, y + CHART_BOX_HEIGHT / 2)
+            drawBox(s, sx, y)
+        }
+
+        // Elbow lines run from the person's box to each related box
+        fromX := x + CHART_BOX_WIDTH / 2
+        fromY, toEdge := y + CHART_BOX_HEIGHT, 0.0
+        if !descendants {
+            fromY, toEdge = y, CHART_BOX_HEIGHT
+        }
+
+        for _, c := range(b.children) {
+            toX := colX(c.x) + CHART_BOX_WIDTH / 2
+            toY := rowY(c.node.Generation) + toEdge
+            midY := (fromY + toY) / 2
+            fmt.Fprintf(w, "<polyline points=\"%.0f,%.0f %.0f,%.0f %.0f,%.0f %.0f,%.0f\" fill=\"none\" stroke=\"#555555\"/>\n",
+                            fromX, fromY, fromX, midY, toX, midY, toX, toY)
+            draw(c)
+        }
+    }
+
+    draw(top)
+
+    fmt.Fprintf(w, "</svg>\n")
+
+    return w.Flush()
+}
+
+// IngestPage reads the records from one "Genealogy Details" HTML page
+func IngestPage(fileName string) ([]*model.Record, []*ParseError, []*UnmatchedSentence) {
+    fmt.Printf("------------ %s -------------\n", path.Base(fileName))
+    htmlText, err := ioutil.ReadFile(fileName)
+
+    if err != nil {
+        log.Fatal(err)
+    }
+
+    doc, err := html.Parse(strings.NewReader(string(htmlText)))
+    if err != nil {
+        log.Fatal(err)
+    }
+
+    procDoc, docErrs := ProcessDocument(doc)
+
+    Normalize(procDoc)
+
+    ProcessSentences(procDoc)
+
+    records, recErrs, unmatched := GenerateRecords(procDoc)
+
+    return records, append(docErrs, recErrs...), unmatched
+}
+
+// IngestSources reads the fowsrc.htm source list page
+func IngestSources(fileName string) []*model.Source {
+    fmt.Printf("------------ %s -------------\n", path.Base(fileName))
+    htmlText, err := ioutil.ReadFile(fileName)
+
+    if err != nil {
+        log.Fatal(err)
+    }
+
+    doc, err := html.Parse(strings.NewReader(string(htmlText)))
+    if err != nil {
+        log.Fatal(err)
+    }
+
+    return ProcessSourceDocument(doc)
+}
+
+// IngestGedcom reads the records and sources from a GEDCOM file
+func IngestGedcom(fileName string) ([]*model.Record, []*model.Source, []*ParseError) {
+    fmt.Printf("------------ %s -------------\n", path.Base(fileName))
+    f, err := os.Open(fileName)
+
+    if err != nil {
+        log.Fatal(err)
+    }
+
+    defer f.Close()
+
+    return ReadGedcom(f, GedcomPrefix(fileName))
+}
+
+func main() {
+    dirName := flag.String("d", "", "directory name")
+    mongoHost := flag.String("t", "", "mongo host")
+    dbName := flag.String("db", "genealogy", "mongo database name")
+    collName := flag.String("c", "people", "mongo collection name")
+    gedcomName := flag.String("g", "", "GEDCOM output file name")
+    sourceCollName := flag.String("sc", "sources", "mongo source collection name")
+    chartRoot := flag.String("chart", "", "identifier of the person to chart")
+    chartDepth := flag.Int("depth", 4, "generations to chart")
+    chartDescendants := flag.Bool("descendants", false, "chart descendants rather than ancestors")
+    dotName := flag.String("dot", "", "Graphviz DOT chart output file name")
+    svgName := flag.String("svg", "", "SVG chart output file name")
+
+    flag.Parse()
+
+    if *dirName == "" {
+        log.Fatal("Error: must specify directory name\n")
+    }
+
+    if *chartRoot != "" && *dotName == "" && *svgName == "" {
+        log.Fatal("Error: must specify -dot or -svg for the chart\n")
+    }
+
+    if *chartDepth < 0 {
+        log.Fatal("Error: -depth must not be negative\n")
+    }
+
+    files, err := ioutil.ReadDir(*dirName)
+
+    if err != nil {
+        log.Fatal(err)
+    }
+
+    var peopleContainer *mgo.Collection
+    var sourceContainer *mgo.Collection
+
+    if *mongoHost != "" {
+        session, err := mgo.Dial(*mongoHost)
+
+        if err != nil {
+            log.Fatal(err)
+        }
+
+        defer session.Close()
+
+        session.SetMode(mgo.Monotonic, true)
+
+        peopleContainer = session.DB(*dbName).C(*collName)
+        sourceContainer = session.DB(*dbName).C(*sourceCollName)
+    }
+
+    records := make(map[string]*model.Record, 0)
+    allRecords := make([]*model.Record, 0)
+    parseErrors := make([]*ParseError, 0)
+    unmatched := make([]*UnmatchedSentence, 0)
+    sources := make([]*model.Source, 0)
+
+    for _, fi := range(files) {
+        var fileRecords []*model.Record
+        var fileErrs []*ParseError
+        var fileUnmatched []*UnmatchedSentence
+
+        fileName := path.Join(*dirName, fi.Name())
+
+        if fi.Name() == SOURCE_PAGE {
+            sources = append(sources, IngestSources(fileName)...)
+            continue
+        } else if strings.HasSuffix(fi.Name(), ".htm") {
+            fileRecords, fileErrs, fileUnmatched = IngestPage(fileName)
+        } else if strings.HasSuffix(fi.Name(), ".ged") {
+            var fileSources []*model.Source
+            fileRecords, fileSources, fileErrs = IngestGedcom(fileName)
+            sources = append(sources, fileSources...)
+        } else {
+            continue
+        }
+
+        for _, rec := range(fileRecords) {
+            if rec.Identifier != "" {
+                // Two people can't share an identifier, and which of them
+                // the other records mean can't be told
+                if _, ok := records[rec.Identifier]; ok {
+                    log.Printf("Warning: skipping `%s` in %s, which was already read",
+                                    rec.Identifier, fi.Name())
+                    continue
+                }
+                records[rec.Identifier] = rec
+            }
+            allRecords = append(allRecords, rec)
+        }
+
+        for _, pe := range(fileErrs) {
+            pe.File = fi.Name()
+            parseErrors = append(parseErrors, pe)
+        }
+
+        for _, u := range(fileUnmatched) {
+            u.File = fi.Name()
+            unmatched = append(unmatched, u)
+        }
+    }
+
+    // Children are only known to belong to a marriage once every page, and
+    // so every child's own Parents line, has been read
+    LabelParents(records)
+    unplaced := AssociateChildren(records)
+
+    for _, u := range(unplaced) {
+        fmt.Printf("Unplaced child: %s (%s) of %s: %s\n",
+                        u.Child.Name, u.Child.Identifier,
+                        u.Parent.Identifier, u.Reason)
+    }
+
+    for _, d := range(FindDuplicates(allRecords)) {
+        fmt.Printf("Possible duplicate: %s (%s) and %s (%s)\n",
+                        d[0].FullName(), d[0].Identifier,
+                        d[1].FullName(), d[1].Identifier)
+    }
+
+    if peopleContainer != nil {
+        if err := StoreRecords(peopleContainer, allRecords); err != nil {
+            log.Fatal(err)
+        }
+
+        if err := StoreSources(sourceContainer, sources); err != nil {
+            log.Fatal(err)
+        }
+    }
+
+    if *gedcomName != "" {
+        out, err := os.Create(*gedcomName)
+
+        if err != nil {
+            log.Fatal(err)
+        }
+
+        err = WriteGedcom(out, allRecords, sources)
+
+        if cerr := out.Close(); err == nil {
+            err = cerr
+        }
+
+        if err != nil {
+            log.Fatal(err)
+        }
+    }
+
+    if *chartRoot != "" {
+        chart, err := BuildChart(records, *chartRoot, *chartDepth, *chartDescendants)
 
-        doc, err := html.Parse(strings.NewReader(string(htmlText)))
         if err != nil {
             log.Fatal(err)
         }
 
-        procDoc := ProcessDocument(doc)
+        writers := []struct {
+            name string
+            write func(io.Writer, *ChartNode, bool) error
+        }{
+            { *dotName, WriteDot },
+            { *svgName, WriteSvg },
+        }
+
+        for _, cw := range(writers) {
+            if cw.name == "" {
+                continue
+            }
+
+            out, err := os.Create(cw.name)
+
+            if err != nil {
+                log.Fatal(err)
+            }
+
+            err = cw.write(out, chart, *chartDescendants)
+
+            if cerr := out.Close(); err == nil {
+                err = cerr
+            }
 
-        Normalize(procDoc)
+            if err != nil {
+                log.Fatal(err)
+            }
+        }
+    }
 
-        ProcessSentences(procDoc)
+    if len(unmatched) > 0 {
+        fmt.Printf("------------ %d unmatched sentences -------------\n", len(unmatched))
 
-        //records := GenerateRecords(procDoc)
-        GenerateRecords(procDoc)
+        for _, u := range(unmatched) {
+            fmt.Printf("%s\n", u)
+        }
     }
 
-    // TODO: need a second pass to associate children with a marriage
+    if len(parseErrors) > 0 {
+        fmt.Printf("------------ %d parse errors -------------\n", len(parseErrors))
+
+        for _, pe := range(parseErrors) {
+            fmt.Printf("%s\n", pe)
+        }
+    }
 }
@@ -0,0 +1,804 @@
+package main
+
+import (
+    "bytes"
+    "code.google.com/p/go.net/html"
+    "genealogy/model"
+    "labix.org/v2/mgo"
+    "labix.org/v2/mgo/bson"
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+    "unicode/utf8"
+)
+
+// fakeCollection keeps upserted documents in memory, keyed on the
+// identifier they were upserted by, as mongo stores them
+type fakeCollection struct {
+    docs map[string][]byte
+    upserts int
+}
+
+func newFakeCollection() *fakeCollection {
+    return &fakeCollection{ docs : make(map[string][]byte, 0) }
+}
+
+func (c *fakeCollection) Upsert(selector interface{}, update interface{}) (*mgo.ChangeInfo, error) {
+    data, err := bson.Marshal(update)
+    if err != nil {
+        return nil, err
+    }
+
+    id := selector.(bson.M)["identifier"].(string)
+
+    info := &mgo.ChangeInfo{ UpsertedId : id }
+    if _, ok := c.docs[id]; ok {
+        info = &mgo.ChangeInfo{ Updated : 1 }
+    }
+
+    c.docs[id] = data
+    c.upserts++
+
+    return info, nil
+}
+
+func (c *fakeCollection) record(t *testing.T, id string) *model.Record {
+    data, ok := c.docs[id]
+    if !ok {
+        t.Fatalf("`%s` was not stored", id)
+    }
+
+    rec := new(model.Record)
+    if err := bson.Unmarshal(data, rec); err != nil {
+        t.Fatal(err)
+    }
+    return rec
+}
+
+func TestStoreRecords(t *testing.T) {
+    graham := model.NewRecord()
+    graham.Identifier = "P3248"
+    graham.FirstName = "Luke"
+    graham.LastName = "Graham"
+    graham.BirthDate = &model.DatedEvent{ Date : model.Date{ Year : 1833 },
+            Place : model.Location{ County : "Tazewell", State : "VA" }, Sources : []string{"12"} }
+    graham.Marriages = append(graham.Marriages, &model.Marriage{ OtherIdentifier : "P3249",
+            OtherName : "Jane Blankenship",
+            Children : []*model.Child{&model.Child{ Identifier : "P3250", Name : "Noah Graham" }} })
+
+    nameless := model.NewRecord()
+    nameless.Text = "A paragraph with no anchor"
+
+    c := newFakeCollection()
+
+    if err := StoreRecords(c, []*model.Record{graham, nameless}); err != nil {
+        t.Fatal(err)
+    }
+
+    if len(c.docs) != 1 {
+        t.Fatalf("stored %d people, want 1", len(c.docs))
+    }
+
+    rec := c.record(t, "P3248")
+    if rec.FullName() != "Luke Graham" {
+        t.Errorf("stored name `%s`, want `Luke Graham`", rec.FullName())
+    }
+    if rec.BirthDate == nil || rec.BirthDate.String() != "in 1833 in Tazewell, VA" {
+        t.Errorf("stored birth `%v`", rec.BirthDate)
+    }
+    if strings.Join(rec.NameWords, " ") != "luke graham" {
+        t.Errorf("stored name words `%v`, want `luke graham`", rec.NameWords)
+    }
+    if len(rec.Marriages) != 1 || len(rec.Marriages[0].Children) != 1 ||
+            rec.Marriages[0].Children[0].Identifier != "P3250" {
+        t.Errorf("stored marriages `%v`", rec.Marriages)
+    }
+
+    // Storing again, as re-running ingest does, replaces the person
+    graham.Occupations = append(graham.Occupations, &model.Occupation{ Name : "farmer" })
+
+    if err := StoreRecords(c, []*model.Record{graham}); err != nil {
+        t.Fatal(err)
+    }
+
+    if len(c.docs) != 1 || c.upserts != 2 {
+        t.Fatalf("stored %d people in %d upserts, want 1 in 2", len(c.docs), c.upserts)
+    }
+
+    if rec := c.record(t, "P3248"); len(rec.Occupations) != 1 || rec.Occupations[0].Name != "farmer" {
+        t.Errorf("stored occupations `%v` after storing again", rec.Occupations)
+    }
+}
+
+// ingestPerson reads the person of one paragraph written as in data/family,
+// the name in bold followed by sentences two spaces apart, the way
+// IngestPage reads a whole page
+func ingestPerson(t *testing.T, name string, body string) (*model.Record, []*ParseError) {
+    page := "<HTML><BODY><A NAME=\"P1\"></A><B>  " + name + "</B> " + body + "<P><HR>" +
+            "<P><A HREF=\"d101.htm\">Go to next 50 names.</A></BODY></HTML>"
+
+    n, err := html.Parse(strings.NewReader(page))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    doc, errs := ProcessDocument(n)
+    Normalize(doc)
+    ProcessSentences(doc)
+
+    records, recErrs, _ := GenerateRecords(doc)
+    if len(records) != 1 {
+        t.Fatalf("read %d people from `%s`, want 1", len(records), body)
+    }
+
+    return records[0], append(errs, recErrs...)
+}
+
+// events gives each event as it reads in a sentence
+func events(dated []*model.DatedEvent) []string {
+    strs := make([]string, 0, len(dated))
+    for _, e := range(dated) {
+        strs = append(strs, e.String())
+    }
+    return strs
+}
+
+func TestProcessCensus(t *testing.T) {
+    tests := []struct {
+        body string
+        census []string
+    }{
+        {"He appeared on the census in 1870 in Barker's Ridge, Wyoming Co., WV.",
+            []string{"in 1870 in Barker's Ridge, Wyoming, WV"}},
+        {"She appeared on the census on 2 Jun 1900 in Alum Ridge, Floyd Co., VA.",
+            []string{"on 2 Jun 1900 in Alum Ridge, Floyd, VA"}},
+        {"He appeared on the census in 1880 in Slab Fork, Wyoming Co., WV.  " +
+                "He appeared on the census in 1850 in Wyoming Co., VA.",
+            []string{"in 1850 in Wyoming, VA", "in 1880 in Slab Fork, Wyoming, WV"}},
+        {"She appeared on the census in 1910.", []string{"in 1910"}},
+    }
+
+    for _, test := range(tests) {
+        rec, errs := ingestPerson(t, "Lewis Graham", test.body)
+        if len(errs) != 0 {
+            t.Errorf("`%s`: %v", test.body, errs[0])
+        }
+        if got := strings.Join(events(rec.Census), "; "); got != strings.Join(test.census, "; ") {
+            t.Errorf("`%s`: census `%s`, want `%s`", test.body, got, strings.Join(test.census, "; "))
+        }
+    }
+}
+
+func TestProcessDeath(t *testing.T) {
+    tests := []struct {
+        body string
+        death string
+        cause string
+    }{
+        {"He died on 12 Apr 1914 in Radford, Montgomery Co., VA.",
+            "on 12 Apr 1914 in Radford, Montgomery, VA", ""},
+        {"She died in Tazewell Co., VA.", "in Tazewell, VA", ""},
+        {"She died of consumption.", "", "of consumption"},
+        {"He died at nineteen.", "", "at nineteen"},
+        {"He (or she) died young.", "", "young"},
+        {"He died.", "", ""},
+        // The first dated death stands, with later ones only filling in
+        // what it leaves out
+        {"She died in 1917.  She died on 5 Apr 1969 in Sophia, Raleigh Co., WV.",
+            "in 1917 in Sophia, Raleigh, WV", ""},
+    }
+
+    for _, test := range(tests) {
+        rec, errs := ingestPerson(t, "Luke Graham", test.body)
+        if len(errs) != 0 {
+            t.Errorf("`%s`: %v", test.body, errs[0])
+        }
+        if rec.Death == nil {
+            t.Errorf("`%s`: no death", test.body)
+            continue
+        }
+        if rec.Death.String() != test.death || rec.DeathCause != test.cause {
+            t.Errorf("`%s`: died `%s` of `%s`, want `%s` of `%s`", test.body,
+                    rec.Death.String(), rec.DeathCause, test.death, test.cause)
+        }
+    }
+
+    // Deaths told in passing are not the person's
+    for _, body := range([]string{
+        "Absent from 1870 census so may have died young.",
+        "Andrew was 83 yrs 6 mo 8 days when he died.",
+        "This marriage may be inaccurate. But if Sara/Sallie died in 1861 then George had a second wife.",
+    }) {
+        if rec, _ := ingestPerson(t, "Andrew Graham", body); rec.Death != nil {
+            t.Errorf("`%s`: died `%s`", body, rec.Death.String())
+        }
+    }
+}
+
+func TestProcessBurial(t *testing.T) {
+    tests := []struct {
+        body string
+        cemetery string
+        place string
+    }{
+        {"She was buried in Little Lake Cemetery, Smith Twp, Peterborough, Ontario, Canada.",
+            "Little Lake Cemetery", "in Smith Twp, Peterborough, Ontario, Canada"},
+        {"He was buried in Blue Ridge Memorial Gardens, Ghent, WV.",
+            "Blue Ridge Memorial Gardens", "in Ghent, WV"},
+        {"He was buried in Osborne, Carroll Co., VA.", "", "in Osborne, Carroll, VA"},
+    }
+
+    for _, test := range(tests) {
+        rec, errs := ingestPerson(t, "Luke Graham", test.body)
+        if len(errs) != 0 {
+            t.Errorf("`%s`: %v", test.body, errs[0])
+        }
+        if rec.Burial == nil {
+            t.Errorf("`%s`: no burial", test.body)
+            continue
+        }
+        if rec.Burial.Cemetery != test.cemetery || rec.Burial.Date.String() != test.place {
+            t.Errorf("`%s`: buried in `%s` `%s`, want `%s` `%s`", test.body,
+                    rec.Burial.Cemetery, rec.Burial.Date.String(), test.cemetery, test.place)
+        }
+    }
+}
+
+func TestAssociateChildren(t *testing.T) {
+    lewis := model.NewRecord()
+    lewis.Identifier = "P3268"
+    lewis.Marriages = append(lewis.Marriages, &model.Marriage{ OtherIdentifier : "P4611",
+            OtherName : "Armenita Jane Blankenship" })
+    lewis.Children = append(lewis.Children, &model.Child{ Identifier : "P8224", Name : "George Washington Graham" },
+            &model.Child{ Identifier : "P7246", Name : "Victoria V Blankenship" })
+
+    george := model.NewRecord()
+    george.Identifier = "P8224"
+    george.Parents = [2]*model.Parent{&model.Parent{ Identifier : "P3268", Name : "Lewis Graham" },
+            &model.Parent{ Identifier : "P4611", Name : "Armenita Jane Blankenship" }}
+
+    unplaced := AssociateChildren(map[string]*model.Record{ "P3268" : lewis, "P8224" : george })
+
+    if len(unplaced) != 1 || unplaced[0].Child.Identifier != "P7246" {
+        t.Fatalf("unplaced `%v`, want Victoria", unplaced)
+    }
+
+    // Each child is listed once, under the marriage when it's known
+    m := lewis.Marriages[0]
+    if len(m.Children) != 1 || m.Children[0].Identifier != "P8224" {
+        t.Errorf("marriage children `%v`, want George", m.Children)
+    }
+    if len(lewis.Children) != 1 || lewis.Children[0].Identifier != "P7246" {
+        t.Errorf("children `%v`, want only Victoria", lewis.Children)
+    }
+    if all := lewis.AllChildren(); len(all) != 2 || all[0].Identifier != "P8224" {
+        t.Errorf("all children `%v`, want George then Victoria", all)
+    }
+}
+
+func TestProcessMarriage(t *testing.T) {
+    tests := []struct {
+        body string
+        // Each marriage as the spouse's identifier and name, then the date
+        marriages []string
+    }{
+        {"He was married to <A HREF=\"d42.htm#P4611\">Armenita Jane Blankenship</A>\n on 19 Oct 1864 in Wyoming Co., WV.",
+            []string{"P4611 Armenita Jane Blankenship on 19 Oct 1864 in Wyoming, WV"}},
+        {"She was married to Stephen Nichols before 1882.", []string{"Stephen Nichols before 1882"}},
+        {"He was married to Mary A in 1855.", []string{"Mary A in 1855"}},
+        {"He was married on 10 Oct 1929 in Baker, OR.", []string{"on 10 Oct 1929 in Baker, OR"}},
+        {"He was married before 1850.", []string{"before 1850"}},
+        {"He was married.", []string{}},
+        {"She was married to <A HREF=\"d40.htm#P100\">John Phillips</A>.  " +
+                "She was married to Robert Duncan on 2 Nov 1901 in Floyd Co., VA.",
+            []string{"P100 John Phillips", "Robert Duncan on 2 Nov 1901 in Floyd, VA"}},
+    }
+
+    for _, test := range(tests) {
+        rec, errs := ingestPerson(t, "Lewis Graham", test.body)
+        if len(errs) != 0 {
+            t.Errorf("`%s`: %v", test.body, errs[0])
+        }
+
+        got := make([]string, 0)
+        for _, m := range(rec.Marriages) {
+            if m.Children == nil {
+                t.Errorf("`%s`: marriage has nil children", test.body)
+            }
+            str := strings.TrimSpace(m.OtherIdentifier + " " + m.OtherName)
+            if m.Date != nil {
+                str = strings.TrimSpace(str + " " + m.Date.String())
+            }
+            got = append(got, str)
+        }
+
+        if strings.Join(got, "; ") != strings.Join(test.marriages, "; ") {
+            t.Errorf("`%s`: married `%s`, want `%s`", test.body, strings.Join(got, "; "),
+                    strings.Join(test.marriages, "; "))
+        }
+    }
+}
+
+func TestParseDate(t *testing.T) {
+    tests := []struct {
+        words string
+        date model.Date
+        n int
+        err bool
+    }{
+        {"5 Sep 1790", model.Date{ Year : 1790, Month : time.September, Day : 5 }, 3, false},
+        {"Sep 1790 in Floyd", model.Date{ Year : 1790, Month : time.September }, 2, false},
+        {"1790.", model.Date{ Year : 1790 }, 1, false},
+        {"1917/18", model.Date{ Year : 1917, Qualifier : model.Between,
+            End : &model.Date{ Year : 1918 } }, 1, false},
+        {"5 Jun 1917/18", model.Date{ Year : 1917, Month : time.June, Day : 5, Qualifier : model.Between,
+            End : &model.Date{ Year : 1918, Month : time.June, Day : 5 } }, 3, false},
+        {"1999/00", model.Date{ Year : 1999, Qualifier : model.Between,
+            End : &model.Date{ Year : 2000 } }, 1, false},
+        {"Mar", model.Date{}, 0, true},
+        {"May in Civil War", model.Date{}, 0, true},
+        {"5 Sep", model.Date{}, 0, true},
+        {"1917/19", model.Date{}, 0, true},
+        {"Sept 1790", model.Date{}, 0, true},
+    }
+
+    for _, test := range(tests) {
+        d, n, err := ParseDate(strings.Fields(test.words))
+        if test.err {
+            if err == nil {
+                t.Errorf("`%s`: parsed `%s`, want an error", test.words, d)
+            }
+            continue
+        }
+
+        if err != nil {
+            t.Errorf("`%s`: %v", test.words, err)
+        } else if !reflect.DeepEqual(d, test.date) || n != test.n {
+            t.Errorf("`%s`: parsed `%s` from %d words, want `%s` from %d", test.words, d, n, test.date, test.n)
+        }
+    }
+}
+
+func TestProcessDatedEvent(t *testing.T) {
+    tests := []struct {
+        words string
+        event string
+        qualifier model.DateQualifier
+    }{
+        {"on 26 Jul 1918 in Basin, Wyoming Co., WV", "on 26 Jul 1918 in Basin, Wyoming, WV", model.Exact},
+        {"in 1846", "in 1846", model.Exact},
+        {"about 1846 in Virginia", "about 1846 in Virginia", model.About},
+        {"before 1882", "before 1882", model.Before},
+        {"after Mar 1900", "after Mar 1900", model.After},
+        {"between 1760 and 1770 in Floyd Co., VA", "between 1760 and 1770 in Floyd, VA", model.Between},
+        {"in 1917/18 in Streeter, Summers Co., WV", "between 1917 and 1918 in Streeter, Summers, WV", model.Between},
+        {"in Virginia", "in Virginia", model.Exact},
+        {"on John Poff's farm", "", model.Exact},
+    }
+
+    for _, test := range(tests) {
+        e, err := ProcessDatedEvent(strings.Fields(test.words))
+        if err != nil {
+            t.Errorf("`%s`: %v", test.words, err)
+            continue
+        }
+        if e.String() != test.event || e.Date.Qualifier != test.qualifier {
+            t.Errorf("`%s`: read `%s` (%q), want `%s` (%q)", test.words, e.String(), e.Date.Qualifier,
+                    test.event, test.qualifier)
+        }
+    }
+
+    for _, words := range([]string{"between 4 Mar 1889 and Mar", "between 1760 to 1770"}) {
+        if e, err := ProcessDatedEvent(strings.Fields(words)); err == nil {
+            t.Errorf("`%s`: read `%s`, want an error", words, e.String())
+        }
+    }
+}
+
+func TestGedcomLongValues(t *testing.T) {
+    rec := model.NewRecord()
+    rec.Identifier = "P3248"
+    rec.FirstName = "Zoë"
+    rec.LastName = "Grim"
+    rec.Aliases = append(rec.Aliases, "Zoë " + strings.Repeat("Brünhilde ", 30) + "Grim")
+    rec.Occupations = append(rec.Occupations, &model.Occupation{
+            // Byte 200, where a line is split, falls inside an "é"
+            Name : "a" + strings.Repeat("é", 150),
+            Date : &model.DatedEvent{ Date : model.Date{ Year : 1918 } } })
+    rec.Events = append(rec.Events, model.NewEvent(model.MilitaryEvent,
+            &model.DatedEvent{ Date : model.Date{ Year : 1862 } }, strings.TrimSpace(strings.Repeat("Civil War ", 25))))
+
+    var out bytes.Buffer
+    if err := WriteGedcom(&out, []*model.Record{rec}, nil); err != nil {
+        t.Fatal(err)
+    }
+
+    for i, l := range(strings.Split(out.String(), "\n")) {
+        if !utf8.ValidString(l) {
+            t.Errorf("line %d `%s` splits a character", i + 1, l)
+        }
+        if len(l) > GEDCOM_MAX_VALUE + len("2 CONC ") {
+            t.Errorf("line %d is %d bytes long", i + 1, len(l))
+        }
+    }
+
+    read, _, errs := ReadGedcom(&out, "")
+    if len(errs) != 0 {
+        t.Fatal(errs[0])
+    }
+    if len(read) != 1 {
+        t.Fatalf("read %d people, want 1", len(read))
+    }
+
+    got := read[0]
+    if len(got.Aliases) != 1 || got.Aliases[0] != rec.Aliases[0] {
+        t.Errorf("read aliases `%v`", got.Aliases)
+    }
+    if len(got.Occupations) != 1 || got.Occupations[0].Name != rec.Occupations[0].Name {
+        t.Errorf("read occupations `%v`", got.Occupations)
+    }
+    if len(got.Events) != 1 || got.Events[0].Detail != rec.Events[0].Detail {
+        t.Errorf("read events `%v`", got.Events)
+    }
+}
+
+func TestProcessOccupation(t *testing.T) {
+    tests := []struct {
+        body string
+        // Each occupation and when and where it was held
+        occupations []string
+    }{
+        {"He was a farmer in 1918 in Basin, Wyoming Co., WV.", []string{"farmer in 1918 in Basin, Wyoming, WV"}},
+        {"He was an automobile mechanic in 1930 in Matoaka, Mercer Co., WV.",
+            []string{"automobile mechanic in 1930 in Matoaka, Mercer, WV"}},
+        {"He was a running electric pump for U.S. Coal & Coke Co. in 1918 in Wilcox, McDowell Co., WV.",
+            []string{"running electric pump for U.S. Coal & Coke Co. in 1918 in Wilcox, McDowell, WV"}},
+        {"He was a miner in iron ore in 1900 in Hiawassie, Pulaski Co., VA.",
+            []string{"miner in iron ore in 1900 in Hiawassie, Pulaski, VA"}},
+        {"He was a coal miner.", []string{"coal miner"}},
+        {"She was a farm laborer in 1880 in Hiawassie, Pulaski Co., VA.  She was a house keeper in 1870.",
+            []string{"house keeper in 1870", "farm laborer in 1880 in Hiawassie, Pulaski, VA"}},
+        // Being widowed, a member or a twin is no occupation
+        {"He was a widower with seven children.", []string{}},
+        {"She was a member, past matron, and past secretary for 15 years of the Rock chapter.", []string{}},
+        {"He was a twin to Roosevelt.", []string{}},
+    }
+
+    for _, test := range(tests) {
+        rec, errs := ingestPerson(t, "John Perry Graham", test.body)
+        if len(errs) != 0 {
+            t.Errorf("`%s`: %v", test.body, errs[0])
+        }
+
+        got := make([]string, 0)
+        for _, o := range(rec.Occupations) {
+            got = append(got, strings.TrimSpace(o.Name + " " + o.Date.String()))
+        }
+
+        if strings.Join(got, "; ") != strings.Join(test.occupations, "; ") {
+            t.Errorf("`%s`: occupations `%s`, want `%s`", test.body, strings.Join(got, "; "),
+                    strings.Join(test.occupations, "; "))
+        }
+    }
+}
+
+func TestProcessDescription(t *testing.T) {
+    tests := []struct {
+        body string
+        // The description's fields, and when and where it was taken
+        height, build, eyes, hair, remarks, date string
+    }{
+        {"He was described as medium height, medium build, brown eyes and dark hair in 1918 in Basin, Wyoming Co., WV.",
+            "medium", "medium", "brown", "dark", "", "in 1918 in Basin, Wyoming, WV"},
+        {"He was described as tall, stout, blue eyes, sandy hair and blind in one eye on 5 Jun 1917 in Springfield Twp, Clark Co., OH.",
+            "tall", "stout", "blue", "sandy", "blind in one eye", "on 5 Jun 1917 in Springfield Twp, Clark, OH"},
+        {"He was described as medium height, medium build, blue eyes and dark hair in 1917/18 in Basin, Wyoming Co., WV.",
+            "medium", "medium", "blue", "dark", "", "between 1917 and 1918 in Basin, Wyoming, WV"},
+        {"He was described as tall, medium build, blue eyes and red hair.",
+            "tall", "medium", "blue", "red", "", ""},
+    }
+
+    for _, test := range(tests) {
+        rec, errs := ingestPerson(t, "John Perry Graham", test.body)
+        if len(errs) != 0 {
+            t.Errorf("`%s`: %v", test.body, errs[0])
+        }
+        if len(rec.Descriptions) != 1 {
+            t.Errorf("`%s`: %d descriptions, want 1", test.body, len(rec.Descriptions))
+            continue
+        }
+
+        d := rec.Descriptions[0]
+        got := []string{d.Height, d.Build, d.Eyes, d.Hair, strings.Join(d.Remarks, ", "), d.Date.String()}
+        want := []string{test.height, test.build, test.eyes, test.hair, test.remarks, test.date}
+        if !reflect.DeepEqual(got, want) {
+            t.Errorf("`%s`: described as %q, want %q", test.body, got, want)
+        }
+    }
+
+    // There is nothing to describe before the date
+    if _, errs := ingestPerson(t, "John Perry Graham", "He was described as in 1918."); len(errs) != 1 {
+        t.Errorf("empty description: %d errors, want 1", len(errs))
+    }
+}
+
+func TestProcessAlias(t *testing.T) {
+    tests := []struct {
+        name string
+        body string
+        aliases []string
+        errors int
+    }{
+        {"John Edward Graham", "He was also known as Jno E.", []string{"Jno E"}, 0},
+        {"Martha Jane Graham", "She was also known as Mattie, Patsy or Jennie.", []string{"Mattie", "Patsy", "Jennie"}, 0},
+        {"Martha Jane Graham", "She was also known as .", []string{}, 1},
+        // The header's alternatives are aliases too, but not this sentence's
+        {"Martha\\Mattie Jane Graham", "She was also known as .", []string{"Mattie Jane Graham"}, 1},
+        {"Martha\\Mattie Jane Graham", "She was also known as Patsy.", []string{"Mattie Jane Graham", "Patsy"}, 0},
+    }
+
+    for _, test := range(tests) {
+        rec, errs := ingestPerson(t, test.name, test.body)
+        if len(errs) != test.errors {
+            t.Errorf("`%s`: %d errors, want %d", test.body, len(errs), test.errors)
+        }
+        if strings.Join(rec.Aliases, "; ") != strings.Join(test.aliases, "; ") {
+            t.Errorf("`%s` `%s`: aliases %q, want %q", test.name, test.body, rec.Aliases, test.aliases)
+        }
+    }
+}
+
+func TestProcessMarriageBond(t *testing.T) {
+    const married = `He was married to <A HREF="d42.htm#P4611">Armenita Jane Blankenship</A> on 19 Oct 1864 in Wyoming Co., WV.  `
+    const remarried = `He was married to <A HREF="d42.htm#P4620">Mary Ellen Cook</A> in 1880.  `
+
+    tests := []struct {
+        body string
+        // The date and bond of each marriage
+        marriages [][2]string
+        errors int
+    }{
+        // From d100.htm, where the bond is for another Jane
+        {married + "This is date of marriage bond for Lewis and a JANE BIRCHFIELD.",
+            [][2]string{{"on 19 Oct 1864 in Wyoming, WV", ""}}, 1},
+        {married + "This is date of marriage bond for Lewis and a Jane Blankenship.",
+            [][2]string{{"", "on 19 Oct 1864 in Wyoming, WV"}}, 0},
+        {married + `This is date of marriage bond for Lewis and <A HREF="d42.htm#P4611">Jane</A>.`,
+            [][2]string{{"", "on 19 Oct 1864 in Wyoming, WV"}}, 0},
+        {married + remarried + "This is date of marriage bond for Lewis and Armenita Blankenship.",
+            [][2]string{{"", "on 19 Oct 1864 in Wyoming, WV"}, {"in 1880", ""}}, 0},
+        {married + "This is date of marriage bond.", [][2]string{{"on 19 Oct 1864 in Wyoming, WV", ""}}, 1},
+        {married + "This is date of marriage bond for Jane Blankenship.", [][2]string{{"", "on 19 Oct 1864 in Wyoming, WV"}}, 0},
+        // Both must be named, and the first isn't Lewis
+        {married + "This is date of marriage bond for Luke and Jane Blankenship.",
+            [][2]string{{"on 19 Oct 1864 in Wyoming, WV", ""}}, 1},
+        {`He was married to <A HREF="d42.htm#P4611">Armenita Jane Blankenship</A>.  ` +
+            "This is date of marriage bond for Lewis and Jane Blankenship.", [][2]string{{"", ""}}, 1},
+    }
+
+    for _, test := range(tests) {
+        rec, errs := ingestPerson(t, "Lewis Graham", test.body)
+        if len(errs) != test.errors {
+            t.Errorf("`%s`: %d errors, want %d", test.body, len(errs), test.errors)
+        }
+
+        got := make([][2]string, 0)
+        for _, m := range(rec.Marriages) {
+            dates := [2]string{}
+            for i, ev := range([]*model.DatedEvent{m.Date, m.Bond}) {
+                if ev != nil {
+                    dates[i] = ev.String()
+                }
+            }
+            got = append(got, dates)
+        }
+        if !reflect.DeepEqual(got, test.marriages) {
+            t.Errorf("`%s`: marriages %q, want %q", test.body, got, test.marriages)
+        }
+    }
+}
+
+func TestProcessResidence(t *testing.T) {
+    tests := []struct {
+        body string
+        // Each residence's address, household and date, separated by "|"
+        residences []string
+    }{
+        {"She resided in 1920 in Hiawassie, Pulaski Co., VA.", []string{"||in 1920 in Hiawassie, Pulaski, VA"}},
+        {"He resided 328A Marleys Creek in 1782 in Bedford Co., VA.",
+            []string{"328A Marleys Creek||in 1782 in Bedford, VA"}},
+        {"She resided with her sister Lena in 1954 in Covington, Alleghany Co., VA.",
+            []string{"|her sister Lena|in 1954 in Covington, Alleghany, VA"}},
+        {"He resided.", []string{}},
+        // A household follows the census or residence it was listed in
+        {"She appeared on the census in 1900 in Slab Fork, Wyoming Co., WV.  She was living with her daughter Chloe E.",
+            []string{"|her daughter Chloe E|in 1900 in Slab Fork, Wyoming, WV"}},
+        {"She resided in 1920 in Hiawassie, Pulaski Co., VA.  She was living with her daughter Chloe E.",
+            []string{"|her daughter Chloe E|in 1920 in Hiawassie, Pulaski, VA"}},
+        {"He was living with his brother James.", []string{"|his brother James|"}},
+    }
+
+    for _, test := range(tests) {
+        rec, errs := ingestPerson(t, "Martha Jane Graham", test.body)
+        if len(errs) != 0 {
+            t.Errorf("`%s`: %v", test.body, errs[0])
+        }
+
+        got := make([]string, 0)
+        for _, r := range(rec.Residences) {
+            got = append(got, r.Address + "|" + r.Household + "|" + r.Date.String())
+        }
+        if !reflect.DeepEqual(got, test.residences) {
+            t.Errorf("`%s`: residences %q, want %q", test.body, got, test.residences)
+        }
+    }
+}
+
+func TestProcessBaptismChristeningAdoption(t *testing.T) {
+    tests := []struct {
+        body string
+        // The baptism, christening and adoption, or "-" for none
+        baptism, christening, adoption string
+    }{
+        {"He was baptized on 30 Apr 1805 in Zion Lutheran Church, Floyd Co., VA.",
+            "on 30 Apr 1805 in Zion Lutheran Church, Floyd, VA", "-", "-"},
+        {"He was baptized on 15 Oct 1887.", "on 15 Oct 1887", "-", "-"},
+        {"He was christened on 17 Jul 1709 in Oberfischbach, Westfalen, Germany.",
+            "-", "on 17 Jul 1709 in Oberfischbach, Westfalen, Germany", "-"},
+        {"She was christened in 1890 in Indian Valley, Floyd Co., VA.", "-", "in 1890 in Indian Valley, Floyd, VA", "-"},
+        {"She was adopted in 1867.", "-", "-", "in 1867"},
+        {"He was adopted in Floyd Co., VA.", "-", "-", "in Floyd, VA"},
+        {"She was adopted.", "-", "-", ""},
+        // Mentioning the adoption again keeps its date
+        {"He was adopted in 1867.  According to the census he was 14 years old when he was adopted.", "-", "-", "in 1867"},
+    }
+
+    for _, test := range(tests) {
+        rec, errs := ingestPerson(t, "John Perry Graham", test.body)
+        if len(errs) != 0 {
+            t.Errorf("`%s`: %v", test.body, errs[0])
+        }
+
+        got := make([]string, 0)
+        for _, ev := range([]*model.DatedEvent{rec.Baptism, rec.Christening, rec.Adoption}) {
+            if ev == nil {
+                got = append(got, "-")
+            } else {
+                got = append(got, ev.String())
+            }
+        }
+        if want := []string{test.baptism, test.christening, test.adoption}; !reflect.DeepEqual(got, want) {
+            t.Errorf("`%s`: baptism, christening and adoption %q, want %q", test.body, got, want)
+        }
+    }
+}
+
+func TestPronounGender(t *testing.T) {
+    tests := []struct {
+        body string
+        gender model.Gender
+    }{
+        {"He was a farmer in 1900.", model.Male},
+        {"She was born in 1850.  Her parents are unknown.", model.Female},
+        // The spouse's pronoun is outnumbered by the person's
+        {`She was born in 1850.  She was married to <A HREF="d100.htm#P3268">Lewis Graham</A>.  He was a farmer.`,
+            model.Female},
+        {"She was born in 1850.  He was a farmer.", model.UnknownGender},
+        {"was born in 1850.", model.UnknownGender},
+    }
+
+    for _, test := range(tests) {
+        rec, _ := ingestPerson(t, "Chloe Graham", test.body)
+        if rec.Gender != test.gender {
+            t.Errorf("`%s`: gender `%v`, want `%v`", test.body, rec.Gender, test.gender)
+        }
+    }
+}
+
+func TestLabelParents(t *testing.T) {
+    person := func(id string, gender model.Gender, parents ...string) *model.Record {
+        rec := model.NewRecord()
+        rec.Identifier = id
+        rec.Gender = gender
+        for i, p := range(parents) {
+            rec.Parents[i] = &model.Parent{ Identifier : p }
+        }
+        return rec
+    }
+
+    records := map[string]*model.Record{
+        // Neither Luke nor Jane's paragraph says, but their children's
+        // parents lines mostly list Luke first
+        "P1" : person("P1", model.UnknownGender),
+        "P2" : person("P2", model.UnknownGender),
+        "P3" : person("P3", model.Male, "P1", "P2"),
+        "P4" : person("P4", model.Female, "P1", "P2"),
+        "P5" : person("P5", model.Female, "P2", "P1"),
+        // A known mother listed first is moved second
+        "P6" : person("P6", model.Female),
+        "P7" : person("P7", model.Male),
+        "P8" : person("P8", model.Male, "P6", "P7"),
+        // Parents without records are taken in the order given
+        "P9" : person("P9", model.Male, "P90", "P91"),
+        // A lone parent whose record says nothing is left unknown
+        "P10" : person("P10", model.Female, "P92"),
+    }
+
+    LabelParents(records)
+
+    if records["P1"].Gender != model.Male || records["P2"].Gender != model.Female {
+        t.Errorf("genders `%v` and `%v`, want male and female", records["P1"].Gender, records["P2"].Gender)
+    }
+
+    tests := []struct {
+        id string
+        father, mother string
+        genders [2]model.Gender
+    }{
+        {"P3", "P1", "P2", [2]model.Gender{model.Male, model.Female}},
+        {"P5", "P1", "P2", [2]model.Gender{model.Male, model.Female}},
+        {"P8", "P7", "P6", [2]model.Gender{model.Male, model.Female}},
+        {"P9", "P90", "P91", [2]model.Gender{model.Male, model.Female}},
+    }
+
+    for _, test := range(tests) {
+        rec := records[test.id]
+        if rec.Father().Identifier != test.father || rec.Mother().Identifier != test.mother {
+            t.Errorf("%s: parents %s and %s, want %s and %s", test.id, rec.Father().Identifier,
+                    rec.Mother().Identifier, test.father, test.mother)
+        }
+        if got := [2]model.Gender{rec.Father().Gender, rec.Mother().Gender}; got != test.genders {
+            t.Errorf("%s: parents' genders `%v`, want `%v`", test.id, got, test.genders)
+        }
+    }
+
+    if p := records["P10"].Parents[0]; p == nil || p.Identifier != "P92" || p.Gender != model.UnknownGender {
+        t.Errorf("lone parent `%v`, want P92 of unknown gender", p)
+    }
+}
+
+func TestSetHeaderName(t *testing.T) {
+    tests := []struct {
+        header string
+        title, first, middle, last, suffix string
+        aliases []string
+    }{
+        {"Luke Graham", "", "Luke", "", "Graham", "", nil},
+        {"John Perry Graham", "", "John", "Perry", "Graham", "", nil},
+        {"Mary Polly Ann Duncan", "", "Mary", "Polly Ann", "Duncan", "", nil},
+        {"Victoria V Graham", "", "Victoria", "V", "Graham", "", nil},
+        {"Zoll Lewis Grim SR", "", "Zoll", "Lewis", "Grim", "SR", nil},
+        {"Luke Graham Jr.", "", "Luke", "", "Graham", "Jr.", nil},
+        {"Rev Norman Cornelius Reed", "Rev", "Norman", "Cornelius", "Reed", "", nil},
+        {"Dr. Graham", "Dr.", "", "", "Graham", "", nil},
+        {"unknown Rogers", "", "", "", "Rogers", "", nil},
+        {"Chloe", "", "Chloe", "", "", "", nil},
+        // Maiden and married names, and other spellings, are aliases
+        {"Victoria V Blankenship\\Graham", "", "Victoria", "V", "Blankenship", "",
+            []string{"Victoria V Graham"}},
+        {"Martha\\Mattie Jane Graham SR", "", "Martha", "Jane", "Graham", "SR",
+            []string{"Mattie Jane Graham SR"}},
+        {"Sarah?\\Sallie Graham", "", "Sarah", "", "Graham", "", []string{"Sallie Graham"}},
+        // Particles belong to the surname, unless one is the given name
+        {"Berendena Van Leeuwen", "", "Berendena", "", "Van Leeuwen", "", nil},
+        {"Maria de la Cruz", "", "Maria", "", "de la Cruz", "", nil},
+        {"Johannes Jacobus van der Berg", "", "Johannes", "Jacobus", "van der Berg", "", nil},
+        {"Van Hale", "", "Van", "", "Hale", "", nil},
+        {"Mrs Van Hale", "Mrs", "", "", "Van Hale", "", nil},
+    }
+
+    for _, test := range(tests) {
+        rec := model.NewRecord()
+        SetHeaderName(rec, test.header)
+
+        got := []string{rec.Title, rec.FirstName, rec.MiddleName, rec.LastName, rec.Suffix}
+        want := []string{test.title, test.first, test.middle, test.last, test.suffix}
+        if !reflect.DeepEqual(got, want) {
+            t.Errorf("`%s`: title, names and suffix %q, want %q", test.header, got, want)
+        }
+        if strings.Join(rec.Aliases, "; ") != strings.Join(test.aliases, "; ") {
+            t.Errorf("`%s`: aliases %q, want %q", test.header, rec.Aliases, test.aliases)
+        }
+    }
+}
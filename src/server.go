@@ -1,47 +1,987 @@
 package main
 
 import (
+    "encoding/json"
+    "errors"
+    "flag"
     "fmt"
+    "genealogy/model"
+    "html"
+    "io"
     "log"
     "labix.org/v2/mgo"
+    "labix.org/v2/mgo/bson"
     "net/http"
-) 
-	
-var peopleContainer *mgo.Collection
-var records []Record
+    "net/url"
+    "strconv"
+    "strings"
+    "unicode"
+    "unicode/utf8"
+)
 
-type Record struct {
-    FirstName string
-    MiddleName string
-    LastName string
-    Text string
+const API_DEFAULT_LIMIT = 50
+const API_MAX_LIMIT = 500
+
+// The database and collection ingest stored the people in
+var dbName string
+var collName string
+
+// People is the collection of people as a request reads it, with the query
+// methods of mgo's collection that the server uses
+type People interface {
+    Find(query interface{}) Query
+    // Close ends the request's session once it's done with the results
+    Close()
 }
 
-func familyTreeHandler(w http.ResponseWriter, r *http.Request) {
-    iter := peopleContainer.Find(nil).Sort("lastname").Iter()
+type Query interface {
+    One(result interface{}) error
+    All(result interface{}) error
+    Count() (int, error)
+    Sort(fields ...string) Query
+    Skip(n int) Query
+    Limit(n int) Query
+    Batch(n int) Query
+    Iter() Iter
+}
+
+type Iter interface {
+    Next(result interface{}) bool
+    Err() error
+    Close() error
+}
+
+// peopleCollection opens the people collection on a session of the
+// request's own, which the caller closes once it's done with the results
+var peopleCollection func() People
+
+// mongoPeople is a People on a mongo session
+type mongoPeople struct {
+    *mgo.Collection
+}
+
+func (c mongoPeople) Find(query interface{}) Query {
+    return mongoQuery{ c.Collection.Find(query) }
+}
+
+func (c mongoPeople) Close() {
+    c.Database.Session.Close()
+}
+
+type mongoQuery struct {
+    *mgo.Query
+}
+
+func (q mongoQuery) Sort(fields ...string) Query {
+    return mongoQuery{ q.Query.Sort(fields...) }
+}
+
+func (q mongoQuery) Skip(n int) Query {
+    return mongoQuery{ q.Query.Skip(n) }
+}
+
+func (q mongoQuery) Limit(n int) Query {
+    return mongoQuery{ q.Query.Limit(n) }
+}
+
+func (q mongoQuery) Batch(n int) Query {
+    return mongoQuery{ q.Query.Batch(n) }
+}
+
+func (q mongoQuery) Iter() Iter {
+    return q.Query.Iter()
+}
+
+// nameQuery matches people with every word of name, ignoring case, as a
+// whole word of their name or an alias, so "John Graham" finds John Edward
+// Graham and "Jno Graham" finds him by his alias Jno E, but "Ann" doesn't
+// find Joanna.  The words are stored, and indexed, by ingest.
+func nameQuery(name string) bson.M {
+    words := model.NameWords(name)
+    if len(words) == 0 {
+        return nil
+    }
+
+    return bson.M{"namewords" : bson.M{"$all" : words}}
+}
+
+// fact writes one labelled line of a person's entry, unless there's nothing
+// to say
+func fact(w io.Writer, label string, value string) {
+    factHtml(w, label, html.EscapeString(value))
+}
+
+// factHtml is fact for a value which is already HTML, such as links
+func factHtml(w io.Writer, label string, value string) {
+    if value != "" {
+        fmt.Fprintf(w, "<br><B>%s:</B> %s\n", label, value)
+    }
+}
+
+// personLink links to the page of a person with a record, and otherwise just
+// gives their name
+func personLink(identifier string, name string) string {
+    if identifier == "" {
+        return html.EscapeString(name)
+    }
+    return fmt.Sprintf("<A HREF=\"/person/%s\">%s</A>", html.EscapeString(identifier),
+            html.EscapeString(name))
+}
 
-    err := iter.All(&records)
+// relativeLink links to a relative's page when they have one
+func relativeLink(rel *Relative) string {
+    if rel.Person == nil {
+        return personLink("", rel.Name)
+    }
+    return personLink(rel.Identifier, rel.Person.FullName())
+}
+
+// eventLabels gives the label each kind of event is listed under.  Kinds
+// which aren't listed, such as ones only ever read from a GEDCOM file, are
+// labelled with their type.
+var eventLabels = map[model.EventType]string{
+    model.BirthEvent : "Born",
+    model.BaptismEvent : "Baptized",
+    model.ChristeningEvent : "Christened",
+    model.AdoptionEvent : "Adopted",
+    model.CensusEvent : "Census",
+    model.OccupationEvent : "Occupation",
+    model.ResidenceEvent : "Resided",
+    model.DescriptionEvent : "Described as",
+    model.MarriageBondEvent : "Marriage bond",
+    model.MarriageEvent : "Married",
+    model.DivorceEvent : "Divorced",
+    model.DeathEvent : "Died",
+    model.BurialEvent : "Buried",
+    model.MilitaryEvent : "Military service",
+}
+
+// eventFact writes an event which is known to have happened even when its
+// date and place are not
+func eventFact(w io.Writer, e *model.Event) {
+    label, ok := eventLabels[e.Type]
+    if !ok {
+        label = upperFirst(string(e.Type))
+    }
+
+    str := e.String()
+    if str == "" {
+        str = "yes"
+    }
+    fact(w, label, str)
+}
+
+// upperFirst upper cases the first letter of a label, so "marriage bond" is
+// "Marriage bond"
+func upperFirst(s string) string {
+    if s == "" {
+        return s
+    }
+    r, n := utf8.DecodeRuneInString(s)
+    return string(unicode.ToUpper(r)) + s[n:]
+}
+
+// peopleSorts maps the sort parameter of /api/people onto the fields it
+// sorts by.  A leading "-" on the parameter reverses the order.
+var peopleSorts = map[string][]string{
+    "name" : []string{"lastname", "firstname", "middlename"},
+    "identifier" : []string{"identifier"},
+    "birth" : []string{"birthdate.date.year", "birthdate.date.month", "birthdate.date.day"},
+    "death" : []string{"death.date.year", "death.date.month", "death.date.day"},
+}
+
+// Relative is a parent, child or spouse of a person as the API returns it.
+// Person is only filled in when the relative has a record of their own.
+type Relative struct {
+    Identifier string `json:"identifier,omitempty"`
+    Name string `json:"name"`
+    // e.g. "father", "daughter" or "wife", or "parent", "child" and "spouse"
+    // when the relative's gender isn't known
+    Role string `json:"role"`
+    Family string `json:"family,omitempty"`
+    Marriage *model.Marriage `json:"marriage,omitempty"`
+    Person *model.Record `json:"person,omitempty"`
+}
+
+// Family is a couple and their children as the API returns them
+type Family struct {
+    Id string `json:"id"`
+    Spouses []*Relative `json:"spouses"`
+    Marriage *model.DatedEvent `json:"marriage,omitempty"`
+    Bond *model.DatedEvent `json:"bond,omitempty"`
+    Divorce *model.DatedEvent `json:"divorce,omitempty"`
+    Children []*Relative `json:"children"`
+}
+
+// Person is a record as /api/people/{identifier} returns it, with every
+// event of their life in Timeline, sorted chronologically
+type Person struct {
+    *model.Record
+    Timeline []*model.Event `json:"timeline"`
+}
+
+// PeoplePage is one page of /api/people
+type PeoplePage struct {
+    Total int `json:"total"`
+    Offset int `json:"offset"`
+    Limit int `json:"limit"`
+    People []model.Record `json:"people"`
+}
+
+// errBadRequest marks an error in the request's parameters
+var errBadRequest = errors.New("bad request")
+
+// relation names a relative's role from their gender
+func relation(g model.Gender, male string, female string, unknown string) string {
+    switch g {
+    case model.Male:
+        return male
+    case model.Female:
+        return female
+    }
+    return unknown
+}
+
+// coupleFamilyId identifies the family of two people who both have
+// records.  It's the same whichever of them it's asked of.
+func coupleFamilyId(a string, b string) string {
+    if b < a {
+        a, b = b, a
+    }
+    return a + "-" + b
+}
+
+// familyId identifies the family of the record's i'th marriage.  When the
+// spouse has no identifier the family is the record's identifier and the
+// number of the marriage, e.g. "P3248-2".
+func familyId(rec *model.Record, i int) string {
+    if other := rec.Marriages[i].OtherIdentifier; other != "" {
+        return coupleFamilyId(rec.Identifier, other)
+    }
+    return rec.Identifier + "-" + strconv.Itoa(i + 1)
+}
+
+// parseFamilyId splits a family identifier into the couple's identifiers,
+// or the one spouse's identifier and the number of their marriage
+func parseFamilyId(id string) (a string, b string, n int, err error) {
+    toks := strings.Split(id, "-")
+    if len(toks) != 2 || toks[0] == "" || toks[1] == "" {
+        return "", "", 0, fmt.Errorf("%w: malformed family `%s`", errBadRequest, id)
+    }
+
+    if n, err := strconv.Atoi(toks[1]); err == nil {
+        if n < 1 {
+            return "", "", 0, fmt.Errorf("%w: malformed family `%s`", errBadRequest, id)
+        }
+        return toks[0], "", n, nil
+    }
 
+    return toks[0], toks[1], 0, nil
+}
+
+// parsePage reads the offset and limit parameters of a list
+func parsePage(r *http.Request) (offset int, limit int, err error) {
+    limit = API_DEFAULT_LIMIT
+
+    if v := r.FormValue("offset"); v != "" {
+        offset, err = strconv.Atoi(v)
+        if err != nil || offset < 0 {
+            return 0, 0, fmt.Errorf("%w: offset must be a non-negative number", errBadRequest)
+        }
+    }
+
+    if v := r.FormValue("limit"); v != "" {
+        limit, err = strconv.Atoi(v)
+        if err != nil || limit < 1 || limit > API_MAX_LIMIT {
+            return 0, 0, fmt.Errorf("%w: limit must be between 1 and %d", errBadRequest, API_MAX_LIMIT)
+        }
+    }
+
+    return offset, limit, nil
+}
+
+// parseSort reads the sort parameter of /api/people, which defaults to name
+func parseSort(r *http.Request) ([]string, error) {
+    key := r.FormValue("sort")
+    if key == "" {
+        key = "name"
+    }
+
+    desc := strings.HasPrefix(key, "-")
+    fields, ok := peopleSorts[strings.TrimPrefix(key, "-")]
+    if !ok {
+        return nil, fmt.Errorf("%w: unknown sort `%s`", errBadRequest, key)
+    }
+
+    sort := make([]string, 0, len(fields) + 1)
+    for _, f := range(fields) {
+        if desc {
+            f = "-" + f
+        }
+        sort = append(sort, f)
+    }
+
+    // Keeps the order of pages stable among people who sort the same
+    return append(sort, "identifier"), nil
+}
+
+func writeJson(w http.ResponseWriter, status int, v interface{}) {
+    w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    w.WriteHeader(status)
+
+    if err := json.NewEncoder(w).Encode(v); err != nil {
+        log.Print(err)
+    }
+}
+
+// apiError answers with the status that fits err, and err's message
+func apiError(w http.ResponseWriter, err error) {
+    status := http.StatusInternalServerError
+    if errors.Is(err, mgo.ErrNotFound) {
+        status = http.StatusNotFound
+    } else if errors.Is(err, errBadRequest) {
+        status = http.StatusBadRequest
+    } else {
+        log.Print(err)
+    }
+
+    writeJson(w, status, map[string]string{"error" : err.Error()})
+}
+
+// apiGet checks that the request only reads
+func apiGet(w http.ResponseWriter, r *http.Request) bool {
+    if r.Method != "GET" && r.Method != "HEAD" {
+        w.Header().Set("Allow", "GET, HEAD")
+        writeJson(w, http.StatusMethodNotAllowed, map[string]string{"error" : "method not allowed"})
+        return false
+    }
+    return true
+}
+
+func findPerson(coll People, identifier string) (*model.Record, error) {
+    rec := new(model.Record)
+    err := coll.Find(bson.M{"identifier" : identifier}).One(rec)
+    if err == mgo.ErrNotFound {
+        return nil, fmt.Errorf("%w: no person `%s`", err, identifier)
+    }
+    return rec, err
+}
+
+// findPeople looks up the records of those identifiers which have one
+func findPeople(coll People, identifiers []string) (map[string]*model.Record, error) {
+    people := make(map[string]*model.Record, 0)
+    if len(identifiers) == 0 {
+        return people, nil
+    }
+
+    var records []*model.Record
+    err := coll.Find(bson.M{"identifier" : bson.M{"$in" : identifiers}}).All(&records)
     if err != nil {
-        log.Fatal(err)
+        return nil, err
+    }
+
+    for _, rec := range(records) {
+        people[rec.Identifier] = rec
+    }
+    return people, nil
+}
+
+// withPeople fills in the records of those relatives which have one
+func withPeople(coll People, relatives []*Relative) error {
+    ids := make([]string, 0, len(relatives))
+    for _, rel := range(relatives) {
+        if rel.Identifier != "" {
+            ids = append(ids, rel.Identifier)
+        }
+    }
+
+    people, err := findPeople(coll, ids)
+    if err != nil {
+        return err
+    }
+
+    for _, rel := range(relatives) {
+        rel.Person = people[rel.Identifier]
+    }
+    return nil
+}
+
+func parentsOf(coll People, rec *model.Record) ([]*Relative, error) {
+    parents := make([]*Relative, 0, 2)
+
+    for _, p := range(rec.Parents) {
+        if p == nil {
+            continue
+        }
+        parents = append(parents, &Relative{ Identifier : p.Identifier, Name : p.Name,
+                Role : relation(p.Gender, "father", "mother", "parent") })
+    }
+
+    return parents, withPeople(coll, parents)
+}
+
+// childrenOf gives the children listed for each of the record's marriages,
+// and those listed on their own, followed by anyone else who names the
+// record as a parent
+func childrenOf(coll People, rec *model.Record) ([]*Relative, error) {
+    children := make([]*Relative, 0)
+    seen := make(map[string]bool, 0)
+
+    add := func(c *model.Child, family string) {
+        if c.Identifier != "" && seen[c.Identifier] {
+            return
+        }
+        seen[c.Identifier] = true
+        children = append(children, &Relative{ Identifier : c.Identifier, Name : c.Name, Family : family })
+    }
+
+    for i, m := range(rec.Marriages) {
+        for _, c := range(m.Children) {
+            add(c, familyId(rec, i))
+        }
+    }
+
+    for _, c := range(rec.Children) {
+        add(c, "")
+    }
+
+    var others []*model.Record
+    err := coll.Find(bson.M{"parents.identifier" : rec.Identifier}).Sort("identifier").All(&others)
+    if err != nil {
+        return nil, err
+    }
+
+    for _, o := range(others) {
+        add(&model.Child{ Identifier : o.Identifier, Name : o.FullName() }, "")
+    }
+
+    if err := withPeople(coll, children); err != nil {
+        return nil, err
+    }
+
+    for _, c := range(children) {
+        if c.Person == nil {
+            c.Role = "child"
+            continue
+        }
+
+        c.Role = relation(c.Person.Gender, "son", "daughter", "child")
+
+        // A child listed on its own still belongs to the family of the
+        // parents it names
+        if c.Family == "" && c.Person.Parents[0] != nil && c.Person.Parents[1] != nil &&
+                c.Person.Parents[0].Identifier != "" && c.Person.Parents[1].Identifier != "" {
+            c.Family = coupleFamilyId(c.Person.Parents[0].Identifier, c.Person.Parents[1].Identifier)
+        }
     }
 
+    return children, nil
+}
+
+func spousesOf(coll People, rec *model.Record) ([]*Relative, error) {
+    spouses := make([]*Relative, 0, len(rec.Marriages))
+
+    for i, m := range(rec.Marriages) {
+        spouses = append(spouses, &Relative{ Identifier : m.OtherIdentifier, Name : m.OtherName,
+                Family : familyId(rec, i), Marriage : m })
+    }
+
+    if err := withPeople(coll, spouses); err != nil {
+        return nil, err
+    }
+
+    for _, s := range(spouses) {
+        s.Role = "spouse"
+        if s.Person != nil {
+            s.Role = relation(s.Person.Gender, "husband", "wife", "spouse")
+        }
+    }
+
+    return spouses, nil
+}
+
+// findFamily puts together the family with the given identifier from the
+// marriages and parents recorded by its members
+func findFamily(coll People, id string) (*Family, error) {
+    a, b, n, err := parseFamilyId(id)
+    if err != nil {
+        return nil, err
+    }
+
+    people, err := findPeople(coll, []string{a, b})
+    if err != nil {
+        return nil, err
+    }
+
+    fam := &Family{ Id : id, Spouses : make([]*Relative, 0, 2), Children : make([]*Relative, 0) }
+
+    spouse := func(identifier string, name string) {
+        rel := &Relative{ Identifier : identifier, Name : name, Role : "spouse", Person : people[identifier] }
+        if rel.Person != nil {
+            rel.Name = rel.Person.FullName()
+            rel.Role = relation(rel.Person.Gender, "husband", "wife", "spouse")
+        }
+        fam.Spouses = append(fam.Spouses, rel)
+    }
+
+    // The marriages recorded by either spouse
+    marriages := make([]*model.Marriage, 0, 2)
+
+    if n > 0 {
+        rec, ok := people[a]
+        if !ok || n > len(rec.Marriages) {
+            return nil, fmt.Errorf("%w: no family `%s`", mgo.ErrNotFound, id)
+        }
+
+        m := rec.Marriages[n - 1]
+        spouse(a, "")
+        spouse(m.OtherIdentifier, m.OtherName)
+        marriages = append(marriages, m)
+    } else {
+        if len(people) == 0 {
+            return nil, fmt.Errorf("%w: no family `%s`", mgo.ErrNotFound, id)
+        }
+
+        spouse(a, "")
+        spouse(b, "")
+
+        for _, pair := range([][2]string{{a, b}, {b, a}}) {
+            if rec, ok := people[pair[0]]; ok {
+                for _, m := range(rec.Marriages) {
+                    if m.OtherIdentifier == pair[1] {
+                        marriages = append(marriages, m)
+                    }
+                }
+            }
+        }
+
+        // The names the spouses are known by in each other's records stand
+        // in for missing records
+        for _, s := range(fam.Spouses) {
+            if s.Person == nil {
+                for _, m := range(marriages) {
+                    if m.OtherIdentifier == s.Identifier && m.OtherName != "" {
+                        s.Name = m.OtherName
+                    }
+                }
+            }
+        }
+    }
+
+    for _, m := range(marriages) {
+        if fam.Marriage == nil {
+            fam.Marriage = m.Date
+        }
+        if fam.Bond == nil {
+            fam.Bond = m.Bond
+        }
+        if fam.Divorce == nil {
+            fam.Divorce = m.Divorce
+        }
+    }
+
+    seen := make(map[string]bool, 0)
+    for _, m := range(marriages) {
+        for _, c := range(m.Children) {
+            if c.Identifier == "" || !seen[c.Identifier] {
+                seen[c.Identifier] = true
+                fam.Children = append(fam.Children, &Relative{ Identifier : c.Identifier, Name : c.Name })
+            }
+        }
+    }
+
+    // Children who name both spouses as their parents, whether or not either
+    // spouse lists them
+    if n == 0 {
+        var others []*model.Record
+        err := coll.Find(bson.M{"$and" : []bson.M{
+            bson.M{"parents.identifier" : a},
+            bson.M{"parents.identifier" : b},
+        }}).Sort("identifier").All(&others)
+        if err != nil {
+            return nil, err
+        }
+
+        for _, o := range(others) {
+            if !seen[o.Identifier] {
+                seen[o.Identifier] = true
+                fam.Children = append(fam.Children, &Relative{ Identifier : o.Identifier, Name : o.FullName() })
+            }
+        }
+
+        if len(marriages) == 0 && len(fam.Children) == 0 {
+            return nil, fmt.Errorf("%w: no family `%s`", mgo.ErrNotFound, id)
+        }
+    }
+
+    if err := withPeople(coll, fam.Children); err != nil {
+        return nil, err
+    }
+
+    for _, c := range(fam.Children) {
+        c.Role = "child"
+        if c.Person != nil {
+            c.Role = relation(c.Person.Gender, "son", "daughter", "child")
+        }
+    }
+
+    return fam, nil
+}
+
+// peopleHandler serves /api/people, a page of everyone whose name matches
+// the name parameter, if given
+func peopleHandler(w http.ResponseWriter, r *http.Request) {
+    if !apiGet(w, r) {
+        return
+    }
+
+    offset, limit, err := parsePage(r)
+    if err != nil {
+        apiError(w, err)
+        return
+    }
+
+    sort, err := parseSort(r)
+    if err != nil {
+        apiError(w, err)
+        return
+    }
+
+    coll := peopleCollection()
+    defer coll.Close()
+
+    query := coll.Find(nameQuery(r.FormValue("name")))
+
+    page := &PeoplePage{ Offset : offset, Limit : limit, People : make([]model.Record, 0) }
+
+    page.Total, err = query.Count()
+    if err != nil {
+        apiError(w, err)
+        return
+    }
+
+    err = query.Sort(sort...).Skip(offset).Limit(limit).All(&page.People)
+    if err != nil {
+        apiError(w, err)
+        return
+    }
+
+    writeJson(w, http.StatusOK, page)
+}
+
+// personHandler serves /api/people/{identifier} and the person's
+// /parents, /children and /spouses
+func personHandler(w http.ResponseWriter, r *http.Request) {
+    if !apiGet(w, r) {
+        return
+    }
+
+    toks := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/people/"), "/")
+    if len(toks) > 2 || toks[0] == "" {
+        http.NotFound(w, r)
+        return
+    }
+
+    relatives := map[string]func(People, *model.Record) ([]*Relative, error){
+        "parents" : parentsOf,
+        "children" : childrenOf,
+        "spouses" : spousesOf,
+    }
+
+    var find func(People, *model.Record) ([]*Relative, error)
+    if len(toks) == 2 {
+        var ok bool
+        if find, ok = relatives[toks[1]]; !ok {
+            http.NotFound(w, r)
+            return
+        }
+    }
+
+    coll := peopleCollection()
+    defer coll.Close()
+
+    rec, err := findPerson(coll, toks[0])
+    if err != nil {
+        apiError(w, err)
+        return
+    }
+
+    if find == nil {
+        writeJson(w, http.StatusOK, &Person{ Record : rec, Timeline : rec.Timeline() })
+        return
+    }
+
+    rels, err := find(coll, rec)
+    if err != nil {
+        apiError(w, err)
+        return
+    }
+
+    writeJson(w, http.StatusOK, rels)
+}
+
+// familyHandler serves /api/families/{id}, where the id is that given for
+// the family of a person's spouse or child
+func familyHandler(w http.ResponseWriter, r *http.Request) {
+    if !apiGet(w, r) {
+        return
+    }
+
+    id := strings.TrimPrefix(r.URL.Path, "/api/families/")
+    if id == "" || strings.Contains(id, "/") {
+        http.NotFound(w, r)
+        return
+    }
+
+    coll := peopleCollection()
+    defer coll.Close()
+
+    fam, err := findFamily(coll, id)
+    if err != nil {
+        apiError(w, err)
+        return
+    }
+
+    writeJson(w, http.StatusOK, fam)
+}
+
+// personPageHandler serves /person/{identifier}, a page of everything known
+// about a person with links to the pages of their parents, spouses and
+// children
+func personPageHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method != "GET" && r.Method != "HEAD" {
+        w.Header().Set("Allow", "GET, HEAD")
+        http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+
+    identifier := strings.TrimPrefix(r.URL.Path, "/person/")
+    if identifier == "" || strings.Contains(identifier, "/") {
+        http.NotFound(w, r)
+        return
+    }
+
+    coll := peopleCollection()
+    defer coll.Close()
+
+    rec, err := findPerson(coll, identifier)
+    if errors.Is(err, mgo.ErrNotFound) {
+        http.NotFound(w, r)
+        return
+    }
+
+    var parents, spouses, children []*Relative
+    if err == nil {
+        parents, err = parentsOf(coll, rec)
+    }
+    if err == nil {
+        spouses, err = spousesOf(coll, rec)
+    }
+    if err == nil {
+        children, err = childrenOf(coll, rec)
+    }
+    if err != nil {
+        log.Print(err)
+        http.Error(w, "the person could not be read", http.StatusInternalServerError)
+        return
+    }
+
+    name := html.EscapeString(strings.TrimSpace(rec.Title + " " + rec.FullName()))
+
+    w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+    fmt.Fprintf(w, "<html><head><title>%s</title></head><body>\n", name)
+    fmt.Fprintf(w, "<H2>%s</H2>\n", name)
+    fact(w, "Sex", string(rec.Gender))
+    fact(w, "Also known as", strings.Join(rec.Aliases, ", "))
+
+    for _, p := range(parents) {
+        factHtml(w, upperFirst(p.Role), relativeLink(p))
+    }
+
+    // Marriages, with their bonds and divorces, are given under each spouse
+    // instead
+    events := make([]*model.Event, 0)
+    for _, e := range(rec.Timeline()) {
+        switch e.Type {
+        case model.MarriageEvent, model.MarriageBondEvent, model.DivorceEvent:
+        default:
+            events = append(events, e)
+        }
+    }
+
+    if len(events) > 0 {
+        fmt.Fprintf(w, "<H3>Events</H3>\n")
+        for _, e := range(events) {
+            eventFact(w, e)
+        }
+    }
+
+    // Children are listed under the spouse they were had with, and those
+    // left over on their own
+    placed := make(map[*Relative]bool, 0)
+
+    for _, s := range(spouses) {
+        fmt.Fprintf(w, "<H3>%s</H3>\n", relativeLink(s))
+
+        eventFact(w, model.NewEvent(model.MarriageEvent, s.Marriage.Date, ""))
+        if s.Marriage.Bond != nil {
+            eventFact(w, model.NewEvent(model.MarriageBondEvent, s.Marriage.Bond, ""))
+        }
+        if s.Marriage.Divorce != nil {
+            eventFact(w, model.NewEvent(model.DivorceEvent, s.Marriage.Divorce, ""))
+        }
+
+        links := make([]string, 0)
+        for _, c := range(children) {
+            if c.Family == s.Family {
+                links = append(links, relativeLink(c))
+                placed[c] = true
+            }
+        }
+        factHtml(w, "Children", strings.Join(links, ", "))
+    }
+
+    links := make([]string, 0)
+    for _, c := range(children) {
+        if !placed[c] {
+            links = append(links, relativeLink(c))
+        }
+    }
+
+    if len(links) > 0 {
+        if len(spouses) > 0 {
+            fmt.Fprintf(w, "<H3>Other children</H3>\n")
+        } else {
+            fmt.Fprintf(w, "<H3>Children</H3>\n")
+        }
+        fmt.Fprintf(w, "%s\n", strings.Join(links, ", "))
+    }
+
+    if rec.Text != "" {
+        fmt.Fprintf(w, "<H3>Notes</H3>\n%s\n", html.EscapeString(rec.Text))
+    }
+
+    fmt.Fprintf(w, "<hr><A HREF=\"/api/people/%s\">JSON</A>\n", html.EscapeString(rec.Identifier))
+    fmt.Fprintf(w, "</body></html>")
+}
+
+// familyTreeHandler lists everyone, or those matching the name parameter,
+// a page at a time.  People are written out as they're
+// read from the database.
+func familyTreeHandler(w http.ResponseWriter, r *http.Request) {
+    var sort []string
+    offset, limit, err := parsePage(r)
+    if err == nil {
+        sort, err = parseSort(r)
+    }
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    coll := peopleCollection()
+    defer coll.Close()
+
+    // One more than the page is read to find whether there's a next page
+    iter := coll.Find(nameQuery(r.FormValue("name"))).Sort(sort...).Skip(offset).Limit(limit + 1).Batch(limit + 1).Iter()
+
+    rec := new(model.Record)
+    more := iter.Next(rec)
+
+    // Nothing has been written yet, so a failed query can still be answered
+    // with an error
+    if !more {
+        if err := iter.Err(); err != nil {
+            iter.Close()
+            log.Print(err)
+            http.Error(w, "the people could not be read", http.StatusInternalServerError)
+            return
+        }
+    }
+
+    w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
     fmt.Fprintf(w, "<html><head></head><body>")
 
-    for _, r := range(records) {
+    n := 0
+    for ; more && n < limit; more, n = iter.Next(rec), n + 1 {
         fmt.Fprintf(w, "<B>")
-        fmt.Fprintf(w, "Name: %s %s %s\n", r.FirstName, r.MiddleName, r.LastName)
+        fmt.Fprintf(w, "Name: %s\n", personLink(rec.Identifier, strings.TrimSpace(rec.Title + " " + rec.FullName())))
         fmt.Fprintf(w, "</B>")
-        fmt.Fprintf(w, "<br><B>Description:</B> %s\n", r.Text)
+        fact(w, "Sex", string(rec.Gender))
+        fact(w, "Also known as", strings.Join(rec.Aliases, ", "))
+
+        for _, p := range(rec.Parents) {
+            if p == nil {
+                continue
+            }
+            switch p.Gender {
+            case model.Male:
+                fact(w, "Father", p.Name)
+            case model.Female:
+                fact(w, "Mother", p.Name)
+            default:
+                fact(w, "Parent", p.Name)
+            }
+        }
+
+        children := make([]string, 0)
+        for _, c := range(rec.AllChildren()) {
+            children = append(children, c.Name)
+        }
+        fact(w, "Children", strings.Join(children, ", "))
+
+        for _, e := range(rec.Timeline()) {
+            eventFact(w, e)
+        }
+
+        fmt.Fprintf(w, "<br><B>Description:</B> %s\n", html.EscapeString(rec.Text))
         fmt.Fprintf(w, "<hr>")
+
+        // Fields missing from the next person mustn't keep this one's values
+        rec = new(model.Record)
     }
+
+    // The page has already gone out as a success, so a failure part way
+    // through can only be noted at the end of it
+    if err := iter.Close(); err != nil {
+        log.Print(err)
+        fmt.Fprintf(w, "<p>The rest of the people could not be read.</p>")
+    } else if more {
+        next := url.Values{}
+        for _, key := range([]string{"name", "sort"}) {
+            if v := r.FormValue(key); v != "" {
+                next.Set(key, v)
+            }
+        }
+        next.Set("offset", strconv.Itoa(offset + limit))
+        next.Set("limit", strconv.Itoa(limit))
+        fmt.Fprintf(w, "<A HREF=\"%s?%s\">Next</A>", html.EscapeString(r.URL.Path), html.EscapeString(next.Encode()))
+    }
+
     fmt.Fprintf(w, "</body></html>")
+}
 
+// serverHandler routes each of the server's paths to its handler
+func serverHandler() http.Handler {
+    mux := http.NewServeMux()
+    mux.HandleFunc("/dulaney", familyTreeHandler)
+    mux.HandleFunc("/api/people", peopleHandler)
+    mux.HandleFunc("/api/people/", personHandler)
+    mux.HandleFunc("/api/families/", familyHandler)
+    mux.HandleFunc("/person/", personPageHandler)
+    return mux
 }
 
 func main() {
+    mongoHost := flag.String("t", "localhost", "mongo host")
+    flag.StringVar(&dbName, "db", "genealogy", "mongo database name")
+    flag.StringVar(&collName, "c", "people", "mongo collection name")
+
+    flag.Parse()
 
-    session, err := mgo.Dial("localhost")
+    session, err := mgo.Dial(*mongoHost)
 
     if err != nil {
         log.Fatal(err)
@@ -51,9 +991,25 @@ func main() {
 
     session.SetMode(mgo.Monotonic, true)
 
-    peopleContainer = session.DB("genealogy").C("people")
+    // Every request works on a copy of the session, which has a socket and
+    // cursors of its own
+    peopleCollection = func() People {
+        return mongoPeople{ session.Copy().DB(dbName).C(collName) }
+    }
 
-    http.HandleFunc("/dulaney", familyTreeHandler) 
+    // Lookups by identifier, parent and name words, and listing by name, are
+    // indexed so that they don't scan and sort the whole collection
+    people := session.DB(dbName).C(collName)
+    for _, key := range([][]string{
+        {"identifier"},
+        {"parents.identifier"},
+        {"lastname", "firstname", "middlename", "identifier"},
+        {"namewords"},
+    }) {
+        if err := people.EnsureIndexKey(key...); err != nil {
+            log.Fatal(err)
+        }
+    }
 
-    log.Fatal(http.ListenAndServe(":80", nil))
+    log.Fatal(http.ListenAndServe(":80", serverHandler()))
 }
@@ -0,0 +1,360 @@
+package main
+
+import (
+    "errors"
+    "genealogy/model"
+    "labix.org/v2/mgo/bson"
+    "net/http"
+    "net/http/httptest"
+    "reflect"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "testing"
+)
+
+var errCanned = errors.New("canned failure")
+
+// cannedPeople serves the same people, already matched and sorted, to every
+// query, and counts the sessions opened and closed on them
+type cannedPeople struct {
+    records []*model.Record
+    // The iterator fails after reading this many, if not negative
+    failAfter int
+    opened int32
+    closed int32
+
+    // The last query's paging, guarded by mu
+    mu sync.Mutex
+    query *cannedQuery
+}
+
+func newCannedPeople(n int) *cannedPeople {
+    people := &cannedPeople{ failAfter : -1 }
+    for i := 0; i < n; i++ {
+        rec := model.NewRecord()
+        rec.Identifier = "P" + string(rune('1' + i))
+        rec.FirstName = "Person" + string(rune('A' + i))
+        rec.LastName = "Graham"
+        people.records = append(people.records, rec)
+    }
+    return people
+}
+
+func (p *cannedPeople) open() People {
+    atomic.AddInt32(&p.opened, 1)
+    return &cannedSession{ people : p }
+}
+
+// cannedSession is one request's session, which like mongo's can't be read
+// from once closed
+type cannedSession struct {
+    people *cannedPeople
+    closed int32
+}
+
+func (s *cannedSession) Find(query interface{}) Query {
+    q := &cannedQuery{ session : s, query : query, limit : -1 }
+    s.people.mu.Lock()
+    s.people.query = q
+    s.people.mu.Unlock()
+    return q
+}
+
+func (s *cannedSession) Close() {
+    if atomic.CompareAndSwapInt32(&s.closed, 0, 1) {
+        atomic.AddInt32(&s.people.closed, 1)
+    }
+}
+
+// cannedQuery records how it was paged, and pages the canned people as
+// mongo would
+type cannedQuery struct {
+    session *cannedSession
+    query interface{}
+    sort []string
+    skip int
+    limit int
+}
+
+func (q *cannedQuery) Sort(fields ...string) Query {
+    q.sort = fields
+    return q
+}
+
+func (q *cannedQuery) Skip(n int) Query {
+    q.skip = n
+    return q
+}
+
+func (q *cannedQuery) Limit(n int) Query {
+    q.limit = n
+    return q
+}
+
+func (q *cannedQuery) Batch(n int) Query {
+    return q
+}
+
+// familyTreeHandler only iterates
+func (q *cannedQuery) One(result interface{}) error {
+    panic("One isn't canned")
+}
+
+func (q *cannedQuery) All(result interface{}) error {
+    panic("All isn't canned")
+}
+
+func (q *cannedQuery) Count() (int, error) {
+    panic("Count isn't canned")
+}
+
+func (q *cannedQuery) Iter() Iter {
+    records := q.session.people.records
+    if q.skip < len(records) {
+        records = records[q.skip:]
+    } else {
+        records = nil
+    }
+    if q.limit >= 0 && q.limit < len(records) {
+        records = records[:q.limit]
+    }
+    return &cannedIter{ session : q.session, records : records, failAfter : q.session.people.failAfter }
+}
+
+type cannedIter struct {
+    session *cannedSession
+    records []*model.Record
+    failAfter int
+    err error
+}
+
+func (it *cannedIter) Next(result interface{}) bool {
+    if it.err == nil && atomic.LoadInt32(&it.session.closed) != 0 {
+        it.err = errors.New("session closed")
+    }
+    if it.err == nil && it.failAfter == 0 {
+        it.err = errCanned
+    }
+    if it.err != nil || len(it.records) == 0 {
+        return false
+    }
+
+    *result.(*model.Record) = *it.records[0]
+    it.records = it.records[1:]
+    it.failAfter--
+    return true
+}
+
+func (it *cannedIter) Err() error {
+    return it.err
+}
+
+func (it *cannedIter) Close() error {
+    return it.err
+}
+
+func TestFamilyTreePaging(t *testing.T) {
+    tests := []struct {
+        path string
+        status int
+        // The people listed, by first name
+        names []string
+        // The Next link, or "" for none
+        next string
+        skip, limit int
+        sort []string
+    }{
+        {"/dulaney", http.StatusOK, []string{"PersonA", "PersonB", "PersonC", "PersonD", "PersonE"}, "",
+            0, API_DEFAULT_LIMIT + 1, []string{"lastname", "firstname", "middlename", "identifier"}},
+        {"/dulaney?limit=2", http.StatusOK, []string{"PersonA", "PersonB"}, "/dulaney?limit=2&amp;offset=2",
+            0, 3, []string{"lastname", "firstname", "middlename", "identifier"}},
+        {"/dulaney?offset=2&limit=2", http.StatusOK, []string{"PersonC", "PersonD"}, "/dulaney?limit=2&amp;offset=4",
+            2, 3, []string{"lastname", "firstname", "middlename", "identifier"}},
+        // The last page is exactly full
+        {"/dulaney?offset=3&limit=2", http.StatusOK, []string{"PersonD", "PersonE"}, "", 3, 3,
+            []string{"lastname", "firstname", "middlename", "identifier"}},
+        {"/dulaney?offset=9", http.StatusOK, []string{}, "", 9, API_DEFAULT_LIMIT + 1,
+            []string{"lastname", "firstname", "middlename", "identifier"}},
+        // The search and order carry over to the next page
+        {"/dulaney?name=graham&sort=-birth&limit=3", http.StatusOK, []string{"PersonA", "PersonB", "PersonC"},
+            "/dulaney?limit=3&amp;name=graham&amp;offset=3&amp;sort=-birth",
+            0, 4, []string{"-birthdate.date.year", "-birthdate.date.month", "-birthdate.date.day", "identifier"}},
+        {"/dulaney?limit=0", http.StatusBadRequest, nil, "", 0, 0, nil},
+        {"/dulaney?offset=-1", http.StatusBadRequest, nil, "", 0, 0, nil},
+        {"/dulaney?sort=height", http.StatusBadRequest, nil, "", 0, 0, nil},
+    }
+
+    for _, test := range(tests) {
+        people := newCannedPeople(5)
+        peopleCollection = people.open
+
+        w := httptest.NewRecorder()
+        familyTreeHandler(w, httptest.NewRequest("GET", test.path, nil))
+        body := w.Body.String()
+
+        if w.Code != test.status {
+            t.Errorf("%s: status %d, want %d: %s", test.path, w.Code, test.status, body)
+        }
+        if test.status != http.StatusOK {
+            if people.opened != 0 {
+                t.Errorf("%s: opened a session for a bad request", test.path)
+            }
+            continue
+        }
+        if people.opened != 1 || people.closed != 1 {
+            t.Errorf("%s: opened %d sessions and closed %d, want 1", test.path, people.opened, people.closed)
+        }
+
+        q := people.query
+        if q.skip != test.skip || q.limit != test.limit || !reflect.DeepEqual(q.sort, test.sort) {
+            t.Errorf("%s: skipped %d, limited to %d and sorted by %v, want %d, %d and %v", test.path,
+                    q.skip, q.limit, q.sort, test.skip, test.limit, test.sort)
+        }
+
+        got := make([]string, 0)
+        for _, rec := range(people.records) {
+            if strings.Contains(body, ">" + rec.FirstName + " Graham</A>") {
+                got = append(got, rec.FirstName)
+            }
+        }
+        if !reflect.DeepEqual(got, test.names) {
+            t.Errorf("%s: listed %v, want %v", test.path, got, test.names)
+        }
+
+        if test.next == "" && strings.Contains(body, ">Next</A>") {
+            t.Errorf("%s: `%s` has a next page", test.path, body)
+        }
+        if test.next != "" && !strings.Contains(body, "<A HREF=\"" + test.next + "\">Next</A>") {
+            t.Errorf("%s: `%s` doesn't link to `%s`", test.path, body, test.next)
+        }
+    }
+}
+
+func TestFamilyTreeReadErrors(t *testing.T) {
+    tests := []struct {
+        failAfter int
+        status int
+        want string
+    }{
+        // Nothing has gone out, so the request fails
+        {0, http.StatusInternalServerError, "could not be read"},
+        // Part of the page has gone out, so its end says so instead of
+        // linking to the next page
+        {1, http.StatusOK, "The rest of the people could not be read"},
+    }
+
+    for _, test := range(tests) {
+        people := newCannedPeople(5)
+        people.failAfter = test.failAfter
+        peopleCollection = people.open
+
+        w := httptest.NewRecorder()
+        familyTreeHandler(w, httptest.NewRequest("GET", "/dulaney?limit=2", nil))
+        body := w.Body.String()
+
+        if w.Code != test.status || !strings.Contains(body, test.want) {
+            t.Errorf("failing after %d: status %d with `%s`, want %d with `%s`", test.failAfter,
+                    w.Code, body, test.status, test.want)
+        }
+        if strings.Contains(body, ">Next</A>") {
+            t.Errorf("failing after %d: `%s` has a next page", test.failAfter, body)
+        }
+        if people.closed != people.opened {
+            t.Errorf("failing after %d: closed %d of %d sessions", test.failAfter, people.closed, people.opened)
+        }
+    }
+}
+
+// TestConcurrentRequests serves pages at once, as the server does, checking
+// that each request reads its people before closing its session.  Run it
+// with -race.
+func TestConcurrentRequests(t *testing.T) {
+    people := newCannedPeople(5)
+    peopleCollection = people.open
+
+    server := httptest.NewServer(serverHandler())
+    defer server.Close()
+
+    const requests = 50
+
+    var wg sync.WaitGroup
+    for i := 0; i < requests; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+
+            resp, err := http.Get(server.URL + "/dulaney?limit=2")
+            if err != nil {
+                t.Error(err)
+                return
+            }
+            resp.Body.Close()
+
+            if resp.StatusCode != http.StatusOK {
+                t.Errorf("status %d, want %d", resp.StatusCode, http.StatusOK)
+            }
+        }()
+    }
+    wg.Wait()
+
+    opened, closed := atomic.LoadInt32(&people.opened), atomic.LoadInt32(&people.closed)
+    if opened != requests || closed != opened {
+        t.Errorf("opened %d sessions and closed %d, want %d", opened, closed, requests)
+    }
+}
+
+func TestNameQuery(t *testing.T) {
+    person := func(title string, first string, middle string, last string, aliases ...string) *model.Record {
+        rec := model.NewRecord()
+        rec.Title, rec.FirstName, rec.MiddleName, rec.LastName = title, first, middle, last
+        rec.Aliases = append(rec.Aliases, aliases...)
+        rec.SetNameWords()
+        return rec
+    }
+
+    john := person("", "John", "Edward", "Graham", "Jno E.")
+    joanna := person("", "Joanna", "", "Hannah")
+    walter := person("Rev.", "Walter", "", "O'Dell")
+
+    tests := []struct {
+        name string
+        rec *model.Record
+        want bool
+    }{
+        {"john graham", john, true},
+        {"JNO  Graham", john, true},
+        {"Jno E. Graham", john, true},
+        {"John Grim", john, false},
+        // Only whole words match
+        {"ann", joanna, false},
+        {"jo", joanna, false},
+        {"joanna", joanna, true},
+        {"al", walter, false},
+        {"rev walter o'dell", walter, true},
+    }
+
+    for _, test := range(tests) {
+        // mongo's $all matches when every word is one of the person's
+        got := true
+        for _, w := range(nameQuery(test.name)["namewords"].(bson.M)["$all"].([]string)) {
+            got = got && hasWord(test.rec.NameWords, w)
+        }
+        if got != test.want {
+            t.Errorf("`%s` matches %v: %v, want %v", test.name, test.rec.NameWords, got, test.want)
+        }
+    }
+
+    if nameQuery(" . ") != nil {
+        t.Errorf("a name of no words is a query")
+    }
+}
+
+func hasWord(words []string, word string) bool {
+    for _, w := range(words) {
+        if w == word {
+            return true
+        }
+    }
+    return false
+}